@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"context"
@@ -16,7 +20,9 @@ import (
 
 	"collabServer/backend/internal/cache"
 	"collabServer/backend/internal/collab"
+	"collabServer/backend/internal/httpapi/handlers"
 	"collabServer/backend/internal/httpapi/middleware"
+	"collabServer/backend/internal/idgen"
 	"collabServer/backend/internal/store"
 	"collabServer/backend/internal/ws"
 )
@@ -35,10 +41,57 @@ type CollabConfig struct {
 	Kafka struct {
 		Brokers []string `mapstructure:"brokers"`
 		Topic   string   `mapstructure:"topic"`
+		// dispatcher（默认）：内存队列异步发送；outbox：事件随操作日志同事务落库，由 relay 发布
+		Mode   string `mapstructure:"mode"`
+		Outbox struct {
+			PollInterval time.Duration `mapstructure:"pollInterval"`
+			BatchSize    int           `mapstructure:"batchSize"`
+		} `mapstructure:"outbox"`
+		Spill struct {
+			Dir       string `mapstructure:"dir"`
+			MaxMB     int64  `mapstructure:"maxMB"`
+			SegmentMB int64  `mapstructure:"segmentMB"`
+		} `mapstructure:"spill"`
+		DeadLetter struct {
+			Topic string `mapstructure:"topic"`
+			File  string `mapstructure:"file"`
+		} `mapstructure:"deadLetter"`
 	} `mapstructure:"Kafka"`
+	Events struct {
+		// kafka（默认）/ redis（Redis Streams）/ memory（进程内 channel）/ none
+		Publisher string `mapstructure:"publisher"`
+		// 消息体编码：json（默认）/ protobuf
+		Encoding    string `mapstructure:"encoding"`
+		RedisStream struct {
+			Stream string `mapstructure:"stream"`
+			MaxLen int64  `mapstructure:"maxLen"`
+		} `mapstructure:"redisStream"`
+	} `mapstructure:"Events"`
 	Auth struct {
 		Path string `mapstructure:"path"`
 	} `mapstructure:"Auth"`
+	Rebase struct {
+		MaxOps int `mapstructure:"maxOps"`
+	} `mapstructure:"Rebase"`
+	Snapshot struct {
+		EveryOps uint64        `mapstructure:"everyOps"`
+		Interval time.Duration `mapstructure:"interval"`
+	} `mapstructure:"Snapshot"`
+	Eviction struct {
+		IdleTimeout    time.Duration `mapstructure:"idleTimeout"`
+		MaxDocs        int           `mapstructure:"maxDocs"`
+		MemoryBudgetMB int64         `mapstructure:"memoryBudgetMB"`
+		CheckInterval  time.Duration `mapstructure:"checkInterval"`
+	} `mapstructure:"Eviction"`
+	Cluster struct {
+		InstanceID string        `mapstructure:"instanceId"`
+		LeaseTTL   time.Duration `mapstructure:"leaseTTL"`
+		NodeID     *int          `mapstructure:"nodeId"` // 未配置为 nil
+	} `mapstructure:"Cluster"`
+	Shutdown struct {
+		Timeout        time.Duration `mapstructure:"timeout"`
+		ReconnectAfter time.Duration `mapstructure:"reconnectAfter"`
+	} `mapstructure:"Shutdown"`
 }
 
 func initConfig() (*CollabConfig, error) {
@@ -83,44 +136,147 @@ func main() {
 	}
 	defer db.Close()
 
-	// === 初始化 Kafka Producer ===
-	kafkaCfg := sarama.NewConfig()
-	// SyncProducer 必须开启 Return.Successes
-	kafkaCfg.Producer.Return.Successes = true
-	kafkaCfg.Producer.RequiredAcks = sarama.WaitForLocal
-	producer, err := sarama.NewSyncProducer(cfg.Kafka.Brokers, kafkaCfg)
-	if err != nil {
-		log.Fatalf("Failed to connect kafka: %v", err)
-	}
-	defer producer.Close()
-
 	presenceCache := cache.NewRedisPresence(rdb)
 	hub := ws.NewHub(presenceCache)
+	// 同一文档的用户可能连在不同实例上：房间广播经 Redis 频道转发给其他实例
+	hub.EnableFanout(cache.NewRedisRoomBus(rdb), cfg.Cluster.InstanceID)
 	snapshotStore := store.NewSnapshotStore(db)
+	opLogStore := store.NewOpLogStore(db)
 	documentStore := store.NewDocumentStore(db)
 
 	// 构造协作引擎具体实现（内存版）
-	kafkatSem := collab.NewSemaphoreControl()
 	wsSem := collab.NewSemaphoreControl()
 
-	// Kafka 本地队列 + worker 重试发送（方案A增强）
-	kafkaDispatcher := collab.NewKafkaDispatcher(
-		producer,
-		cfg.Kafka.Topic,
-		kafkatSem,
-		collab.KafkaDispatcherOptions{
-			//  Go 允许在数字里用下划线做分隔符，方便阅读
-			QueueSize:   10_000,
-			Workers:     4,
-			MaxRetry:    3,
-			BaseBackoff: 50 * time.Millisecond,
-			MaxBackoff:  1 * time.Second,
-		},
+	// 操作 ID / 文档 ID：Snowflake，多实例部署时每个实例的 nodeId 必须不同，
+	// 因此启用集群模式时必须显式配置，不能默认为 0；单实例部署未配置时用 0
+	nodeID := 0
+	if cfg.Cluster.NodeID != nil {
+		nodeID = *cfg.Cluster.NodeID
+	} else if cfg.Cluster.InstanceID != "" {
+		log.Fatalf("cluster.nodeId must be set explicitly when cluster.instanceId is configured")
+	}
+	ids, err := idgen.New(nodeID)
+	if err != nil {
+		log.Fatalf("init id generator failed: %v", err)
+	}
+	serviceOpts := []collab.ServiceOption{
+		collab.WithIDGenerator(ids),
+		// 已应用的操作（包括快照回滚）按 revision 顺序广播给房间内所有连接
+		collab.WithOpListener(hub.BroadcastOp),
+		collab.WithMaxRebaseOps(cfg.Rebase.MaxOps),
+	}
+
+	// 多实例部署：配置了 instanceId 时按文档租约划分归属，非 owner 实例让客户端重定向
+	if cfg.Cluster.InstanceID != "" {
+		leaseTTL := cfg.Cluster.LeaseTTL
+		if leaseTTL <= 0 {
+			leaseTTL = 10 * time.Second
+		}
+		leases := cache.NewLeaseManager(rdb, cfg.Cluster.InstanceID, leaseTTL)
+		defer leases.Close(context.Background())
+		serviceOpts = append(serviceOpts, collab.WithOwnership(leases))
+	}
+
+	// 文档事件出口
+	eventEncoding, err := collab.ParseEventEncoding(cfg.Events.Encoding)
+	if err != nil {
+		log.Fatalf("invalid events.encoding: %v", err)
+	}
+	var (
+		events          collab.EventPublisher
+		kafkaDispatcher *collab.KafkaDispatcher
+		outboxRelay     *collab.OutboxRelay
 	)
+	switch cfg.Events.Publisher {
+	case "", "kafka":
+		// === 初始化 Kafka Producer ===
+		kafkaCfg := sarama.NewConfig()
+		// SyncProducer 必须开启 Return.Successes
+		kafkaCfg.Producer.Return.Successes = true
+		kafkaCfg.Producer.RequiredAcks = sarama.WaitForLocal
+		producer, err := sarama.NewSyncProducer(cfg.Kafka.Brokers, kafkaCfg)
+		if err != nil {
+			log.Fatalf("Failed to connect kafka: %v", err)
+		}
+		defer producer.Close()
 
-	svc := collab.NewInMemoryService(snapshotStore, documentStore, producer, cfg.Kafka.Topic, kafkaDispatcher)
+		if cfg.Kafka.Mode == "outbox" {
+			// 事务性 outbox：事件与操作日志 / 快照同一事务写入，其余事件也写 outbox，relay 按文档顺序发布到 Kafka
+			outboxStore := store.NewOutboxStore(db)
+			outboxRelay = collab.NewOutboxRelay(outboxStore, producer, cfg.Kafka.Topic, collab.OutboxRelayOptions{
+				PollInterval: cfg.Kafka.Outbox.PollInterval,
+				BatchSize:    cfg.Kafka.Outbox.BatchSize,
+				Encoding:     eventEncoding,
+			})
+			serviceOpts = append(serviceOpts, collab.WithOutbox(opLogStore, snapshotStore))
+			events = outboxStore
+			break
+		}
+
+		// Kafka 本地队列 + worker 重试发送（方案A增强）
+		kafkaDispatcher = collab.NewKafkaDispatcher(
+			producer,
+			cfg.Kafka.Topic,
+			collab.NewSemaphoreControl(),
+			collab.KafkaDispatcherOptions{
+				//  Go 允许在数字里用下划线做分隔符，方便阅读
+				QueueSize:   10_000,
+				Workers:     4,
+				MaxRetry:    3,
+				BaseBackoff: 50 * time.Millisecond,
+				MaxBackoff:  1 * time.Second,
+				// 队列满 / Kafka 不可用时写入本地磁盘，恢复后按顺序补发
+				SpillDir:          cfg.Kafka.Spill.Dir,
+				SpillMaxBytes:     cfg.Kafka.Spill.MaxMB << 20,
+				SpillSegmentBytes: cfg.Kafka.Spill.SegmentMB << 20,
+				// 无法投递的事件进死信 topic（失败时写本地文件），用 cmd/dlq_redrive 重新投递
+				DeadLetterTopic: cfg.Kafka.DeadLetter.Topic,
+				DeadLetterFile:  cfg.Kafka.DeadLetter.File,
+				Encoding:        eventEncoding,
+			},
+		)
+		events = kafkaDispatcher
+	case "redis":
+		events = cache.NewRedisStreamPublisher(rdb, cfg.Events.RedisStream.Stream, cfg.Events.RedisStream.MaxLen, eventEncoding)
+	case "memory":
+		// 本地开发：不依赖外部组件，事件直接打到日志
+		ch := collab.NewChannelPublisher(1024)
+		go func() {
+			for evt := range ch.Events() {
+				log.Printf("event %s doc=%s id=%s payload=%s", evt.EventType, evt.DocID, evt.EventID, evt.Payload)
+			}
+		}()
+		events = ch
+	case "none":
+		events = collab.NopPublisher{}
+	default:
+		log.Fatalf("unknown events.publisher %q", cfg.Events.Publisher)
+	}
+
+	svc := collab.NewInMemoryService(snapshotStore, opLogStore, documentStore, events, serviceOpts...)
 	manager := ws.NewManager(hub, svc, wsSem)
 
+	// 后台自动快照：每 N 个操作 / 每 T 时间 / 房间清空时
+	snapshotScheduler := collab.NewSnapshotScheduler(svc, collab.SnapshotSchedulerOptions{
+		EveryOps: cfg.Snapshot.EveryOps,
+		Interval: cfg.Snapshot.Interval,
+	})
+	hub.OnRoomEmpty(snapshotScheduler.Notify)
+	// 成员加入 / 离开事件
+	hub.OnMembership(func(docID string, userID uint64, username string, joined bool) {
+		svc.RecordMembership(context.Background(), docID, userID, username, joined)
+	})
+
+	// 空闲文档淘汰：先快照再移出内存，下次访问时重新加载
+	documentEvictor := collab.NewDocumentEvictor(svc, collab.EvictionOptions{
+		IdleTimeout:  cfg.Eviction.IdleTimeout,
+		MaxDocs:      cfg.Eviction.MaxDocs,
+		MemoryBudget: cfg.Eviction.MemoryBudgetMB << 20,
+	}, cfg.Eviction.CheckInterval)
+
+	// 快照历史：列表 / 查看 / 回滚（回滚结果经 op 监听器实时推送给房间内协作者）
+	snapshotHandler := handlers.NewSnapshotHandler(svc)
+
 	r := gin.New()
 	// 中间件
 	r.Use(gin.Logger())
@@ -137,7 +293,79 @@ func main() {
 			"message": "ok",
 		})
 	})
+	// 快照历史：列表 / 查看 / 回滚
+	collab.GET("/documents/:docId/snapshots", snapshotHandler.List)
+	collab.GET("/documents/:docId/snapshots/:revision", snapshotHandler.Get)
+	collab.POST("/documents/:docId/snapshots/:revision/restore", snapshotHandler.Restore)
+	// 任意历史版本的文档内容（快照 + 重放操作）
+	collab.GET("/documents/:docId/revisions/:revision", snapshotHandler.ContentAt)
+	// 各文档快照滞后情况（未快照操作数 / 脏了多久）
+	collab.GET("/metrics/snapshots", func(c *gin.Context) {
+		c.JSON(200, gin.H{"documents": svc.SnapshotLags()})
+	})
+	// Kafka 事件派发：已发送 / 失败 / 内存队列 / 磁盘溢出积压
+	collab.GET("/metrics/kafka", func(c *gin.Context) {
+		if kafkaDispatcher == nil {
+			c.JSON(404, gin.H{"error": "kafka dispatcher not enabled"})
+			return
+		}
+		c.JSON(200, kafkaDispatcher.Stats())
+	})
+	// outbox 模式：relay 已发布 / 发送失败次数
+	collab.GET("/metrics/outbox", func(c *gin.Context) {
+		if outboxRelay == nil {
+			c.JSON(404, gin.H{"error": "outbox mode not enabled"})
+			return
+		}
+		c.JSON(200, outboxRelay.Stats())
+	})
+	// 内存文档缓存：常驻数量 / 估算内存 / 淘汰与重新加载次数
+	collab.GET("/metrics/cache", func(c *gin.Context) {
+		c.JSON(200, svc.CacheStats())
+	})
 
 	port := cfg.Running.Port
-	_ = r.Run(fmt.Sprintf(":%d", port))
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("listen failed: %v", err)
+		}
+	}()
+
+	// 等待 SIGINT / SIGTERM 后优雅停机
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-sigCtx.Done()
+	log.Printf("shutdown signal received, draining")
+
+	timeout := cfg.Shutdown.Timeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// 1. 拒绝新连接，通知在线客户端稍后重连（多实例部署时重连到接管的实例）
+	notified := manager.Shutdown(shutdownCtx, cfg.Shutdown.ReconnectAfter)
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http server shutdown error: %v", err)
+	}
+	// 2. 不再有新的操作：停止淘汰，把所有脏文档快照落库
+	documentEvictor.Stop()
+	if err := snapshotScheduler.Stop(shutdownCtx); err != nil {
+		log.Printf("flush snapshots on shutdown error: %v", err)
+	}
+	// 3. 在期限内把 Kafka 队列里剩余的事件发完
+	if kafkaDispatcher != nil {
+		stats, err := kafkaDispatcher.Stop(shutdownCtx)
+		if err != nil {
+			log.Printf("kafka dispatcher drain error: %v", err)
+		}
+		log.Printf("kafka events sent=%d failed=%d unsent=%d", stats.Sent, stats.Failed, stats.Unsent)
+	}
+	// outbox 中未发布的事件留在表里，下次启动（或其他实例）继续发布
+	if outboxRelay != nil {
+		outboxRelay.Stop()
+	}
+	log.Printf("shutdown complete: notified %d connections", notified)
 }
@@ -0,0 +1,95 @@
+// dlq_redrive：把 collab-service 的死信事件重新投递到 doc-ops topic。
+//
+//	go run ./backend/cmd/dlq_redrive               # 死信 topic + 本地 DLQ 文件
+//	go run ./backend/cmd/dlq_redrive -source file  # 只处理本地 DLQ 文件
+//
+// 在确认导致失败的原因（Kafka 故障、消息大小限制等）已经解决后执行。
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/IBM/sarama"
+	"github.com/spf13/viper"
+
+	"collabServer/backend/internal/collab"
+)
+
+type redriveConfig struct {
+	Kafka struct {
+		Brokers    []string `mapstructure:"brokers"`
+		Topic      string   `mapstructure:"topic"`
+		DeadLetter struct {
+			Topic string `mapstructure:"topic"`
+			File  string `mapstructure:"file"`
+			Group string `mapstructure:"redriveGroup"`
+		} `mapstructure:"deadLetter"`
+	} `mapstructure:"Kafka"`
+}
+
+func loadConfig() (*redriveConfig, error) {
+	cfg := &redriveConfig{}
+	v := viper.New()
+	v.SetConfigName("collabConfig")
+	v.SetConfigType("yaml")
+	v.AddConfigPath("./backend/config")
+	v.AddConfigPath("./config")
+	v.AddConfigPath(".")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func main() {
+	source := flag.String("source", "all", "死信来源：topic / file / all")
+	flag.Parse()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("load config failed: %v", err)
+	}
+	group := cfg.Kafka.DeadLetter.Group
+	if group == "" {
+		group = "collab-dlq-redrive"
+	}
+
+	kafkaCfg := sarama.NewConfig()
+	kafkaCfg.Producer.Return.Successes = true
+	kafkaCfg.Producer.RequiredAcks = sarama.WaitForAll
+	client, err := sarama.NewClient(cfg.Kafka.Brokers, kafkaCfg)
+	if err != nil {
+		log.Fatalf("connect kafka failed: %v", err)
+	}
+	defer client.Close()
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		log.Fatalf("create producer failed: %v", err)
+	}
+	defer producer.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if (*source == "all" || *source == "topic") && cfg.Kafka.DeadLetter.Topic != "" {
+		res, err := collab.RedriveDeadLetterTopic(ctx, client, group, cfg.Kafka.DeadLetter.Topic, producer, cfg.Kafka.Topic)
+		log.Printf("redrive from topic %s: %d redriven, %d parked", cfg.Kafka.DeadLetter.Topic, res.Redriven, res.Parked)
+		if err != nil {
+			log.Fatalf("redrive from topic failed: %v", err)
+		}
+	}
+	if (*source == "all" || *source == "file") && cfg.Kafka.DeadLetter.File != "" {
+		res, err := collab.RedriveDeadLetterFile(cfg.Kafka.DeadLetter.File, producer, cfg.Kafka.Topic)
+		log.Printf("redrive from file %s: %d redriven, %d parked", cfg.Kafka.DeadLetter.File, res.Redriven, res.Parked)
+		if err != nil {
+			log.Fatalf("redrive from file failed: %v", err)
+		}
+	}
+}
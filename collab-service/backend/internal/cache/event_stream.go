@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"collabServer/backend/internal/collab"
+)
+
+// 基于 Redis Streams 的 collab.EventPublisher：所有文档的事件按发生顺序 XADD 到同一个 stream，
+// 消费方用 XREADGROUP 消费。每条记录的字段：
+// eventId / eventType / docId / schemaVersion / contentType / data（按 encoding 编码的完整事件）
+type redisStreamPublisher struct {
+	rdb      redis.UniversalClient
+	stream   string
+	maxLen   int64
+	encoding collab.EventEncoding
+}
+
+// NewRedisStreamPublisher maxLen > 0 时按近似长度裁剪（MAXLEN ~），避免 stream 无限增长
+func NewRedisStreamPublisher(rdb redis.UniversalClient, stream string, maxLen int64, encoding collab.EventEncoding) collab.EventPublisher {
+	if stream == "" {
+		stream = keyEventStream
+	}
+	return &redisStreamPublisher{rdb: rdb, stream: stream, maxLen: maxLen, encoding: encoding}
+}
+
+func (p *redisStreamPublisher) Publish(ctx context.Context, evt collab.DocEvent) error {
+	data, err := collab.EncodeEvent(evt, p.encoding)
+	if err != nil {
+		return err
+	}
+	return p.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: p.maxLen > 0,
+		Values: []any{
+			"eventId", evt.EventID,
+			"eventType", evt.EventType,
+			"docId", evt.DocID,
+			"schemaVersion", strconv.Itoa(evt.SchemaVersion),
+			"contentType", p.encoding.ContentType(),
+			"data", data,
+		},
+	}).Err()
+}
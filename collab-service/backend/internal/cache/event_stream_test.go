@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+
+	"collabServer/backend/internal/collab"
+)
+
+func TestRedisStreamPublisher_AppendsEncodedEvent(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	// 若 Redis 未启动则跳过
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("skip: redis not available: %v", err)
+	}
+	ctx := context.Background()
+	stream := "test:doc-events:" + time.Now().Format("150405.000000")
+	defer rdb.Del(ctx, stream)
+
+	evt, err := collab.NewDocEvent("evt-1", collab.EventSnapshotSaved, "doc-1", time.Now(), collab.SnapshotSavedPayload{Revision: 3})
+	if err != nil {
+		t.Fatalf("NewDocEvent() error = %v", err)
+	}
+	for _, enc := range []collab.EventEncoding{collab.EncodingJSON, collab.EncodingProtobuf} {
+		pub := NewRedisStreamPublisher(rdb, stream, 100, enc)
+		if err := pub.Publish(ctx, evt); err != nil {
+			t.Fatalf("Publish(%s) error = %v", enc, err)
+		}
+	}
+
+	msgs, err := rdb.XRange(ctx, stream, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange error: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("stream has %d entries, want 2", len(msgs))
+	}
+	for i, enc := range []collab.EventEncoding{collab.EncodingJSON, collab.EncodingProtobuf} {
+		want, _ := collab.EncodeEvent(evt, enc)
+		v := msgs[i].Values
+		if v["eventId"] != "evt-1" || v["eventType"] != collab.EventSnapshotSaved || v["docId"] != "doc-1" ||
+			v["schemaVersion"] != "1" || v["contentType"] != enc.ContentType() || v["data"] != string(want) {
+			t.Fatalf("entry %d = %v, want %s encoded event", i, v, enc.ContentType())
+		}
+	}
+}
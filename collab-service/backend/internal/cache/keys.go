@@ -7,6 +7,11 @@ import "fmt"
 // - namesKey(docID):          房间内 userId→username 映射（Hash）
 // - docsKey():                文档索引集合（Set<docID>）
 
+// - leaseKey(docID):          文档归属租约（Hash{owner, token}，带 TTL）
+// - fenceKey(docID):          租约 fencing token 计数器（String，INCR 单调递增）
+// - roomChannel(docID):       房间跨实例广播频道（sharded pub/sub，按 hash tag 落到固定分片）
+// - keyEventStream:           文档事件 stream 的默认键（Redis Streams 事件发布）
+
 // 房间集合 room:Set
 // 名字表 names:Hash
 // 文档索引 docs:Set
@@ -15,8 +20,19 @@ const (
 	keyRoomFmt  = "presence:room:{docID:%s}"       // ZSet<userId, expireAtUnix>
 	keyNamesFmt = "presence:room:names:{docID:%s}" // Hash<userId -> username>
 	keyDocsSet  = "presence:docs"                  // Set<docID>
+
+	// 同一文档的租约与计数器使用相同 hash tag，Lua 脚本中可以同时访问
+	keyLeaseFmt = "collab:lease:{docID:%s}"       // Hash<owner, token>
+	keyFenceFmt = "collab:lease:fence:{docID:%s}" // String（INCR）
+
+	keyRoomChannelFmt = "collab:room:{docID:%s}" // Pub/Sub channel
+
+	keyEventStream = "collab:doc-events" // Stream
 )
 
-func roomKey(docID string) string  { return fmt.Sprintf(keyRoomFmt, docID) }
-func namesKey(docID string) string { return fmt.Sprintf(keyNamesFmt, docID) }
-func docsKey() string              { return keyDocsSet }
+func roomKey(docID string) string     { return fmt.Sprintf(keyRoomFmt, docID) }
+func namesKey(docID string) string    { return fmt.Sprintf(keyNamesFmt, docID) }
+func docsKey() string                 { return keyDocsSet }
+func leaseKey(docID string) string    { return fmt.Sprintf(keyLeaseFmt, docID) }
+func fenceKey(docID string) string    { return fmt.Sprintf(keyFenceFmt, docID) }
+func roomChannel(docID string) string { return fmt.Sprintf(keyRoomChannelFmt, docID) }
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// 获取（或续期自己的）租约。
+// 返回 {acquired(0/1), owner, token}
+var acquireLeaseScript = redis.NewScript(`
+-- KEYS[1] = leaseKey(docID)
+-- KEYS[2] = fenceKey(docID)
+-- ARGV[1] = 本实例 ID
+-- ARGV[2] = ttl (ms)
+local owner = redis.call("HGET", KEYS[1], "owner")
+if owner and owner ~= ARGV[1] then
+	return {0, owner, redis.call("HGET", KEYS[1], "token")}
+end
+local token
+if owner == ARGV[1] then
+	token = redis.call("HGET", KEYS[1], "token")
+else
+	token = tostring(redis.call("INCR", KEYS[2]))
+	redis.call("HSET", KEYS[1], "owner", ARGV[1], "token", token)
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return {1, ARGV[1], token}
+`)
+
+// 只有 owner 与 token 都匹配时才续期
+var renewLeaseScript = redis.NewScript(`
+-- KEYS[1] = leaseKey(docID)
+-- ARGV[1] = 本实例 ID, ARGV[2] = token, ARGV[3] = ttl (ms)
+if redis.call("HGET", KEYS[1], "owner") == ARGV[1] and redis.call("HGET", KEYS[1], "token") == ARGV[2] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+return 0
+`)
+
+// 只有 owner 与 token 都匹配时才删除
+var releaseLeaseScript = redis.NewScript(`
+-- KEYS[1] = leaseKey(docID)
+-- ARGV[1] = 本实例 ID, ARGV[2] = token
+if redis.call("HGET", KEYS[1], "owner") == ARGV[1] and redis.call("HGET", KEYS[1], "token") == ARGV[2] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type heldLease struct {
+	token     uint64
+	expiresAt time.Time
+}
+
+// LeaseManager：基于 Redis 的文档归属租约（实现 collab.Ownership）。
+// - 每个文档一个带 TTL 的租约，owner 为实例 ID（即客户端应连接的地址）
+// - 每次新获得租约时 token 通过 INCR 单调递增，作为 fencing token
+// - 每次 Ensure 都在 Redis 上确认并续期；后台每 ttl/3 续期一次本实例持有的所有租约，续期失败视为丢失并回调 onLost
+type LeaseManager struct {
+	rdb        redis.UniversalClient
+	instanceID string
+	ttl        time.Duration
+
+	mu     sync.Mutex
+	held   map[string]heldLease
+	onLost func(docID string)
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewLeaseManager(rdb redis.UniversalClient, instanceID string, ttl time.Duration) *LeaseManager {
+	m := &LeaseManager{
+		rdb:        rdb,
+		instanceID: instanceID,
+		ttl:        ttl,
+		held:       make(map[string]heldLease),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go m.renewLoop()
+	return m
+}
+
+func (m *LeaseManager) OnLost(fn func(docID string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onLost = fn
+}
+
+// Ensure 每次都经 Redis 确认（同时续期）：租约被其他实例接管后，下一次访问就能发现并回调 onLost，
+// 而不是等本地记录过期。存储层的 fencing 兜住确认之后、写入之前丢失租约的情况。
+func (m *LeaseManager) Ensure(ctx context.Context, docID string) (string, uint64, bool, error) {
+	start := time.Now()
+	res, err := acquireLeaseScript.Run(ctx, m.rdb, []string{leaseKey(docID), fenceKey(docID)},
+		m.instanceID, m.ttl.Milliseconds()).Slice()
+	if err != nil {
+		return "", 0, false, err
+	}
+	acquired, owner, token, err := parseLeaseReply(res)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if !acquired {
+		m.forget(docID)
+		return owner, 0, false, nil
+	}
+
+	m.mu.Lock()
+	m.held[docID] = heldLease{token: token, expiresAt: start.Add(m.ttl)}
+	m.mu.Unlock()
+	return m.instanceID, token, true, nil
+}
+
+func (m *LeaseManager) Release(ctx context.Context, docID string) error {
+	m.mu.Lock()
+	lease, ok := m.held[docID]
+	delete(m.held, docID)
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return releaseLeaseScript.Run(ctx, m.rdb, []string{leaseKey(docID)}, m.instanceID, lease.token).Err()
+}
+
+// Close 停止续期并释放本实例持有的所有租约，让其他实例立即接管
+func (m *LeaseManager) Close(ctx context.Context) error {
+	close(m.stop)
+	<-m.done
+
+	m.mu.Lock()
+	docIDs := make([]string, 0, len(m.held))
+	for docID := range m.held {
+		docIDs = append(docIDs, docID)
+	}
+	m.mu.Unlock()
+
+	var firstErr error
+	for _, docID := range docIDs {
+		if err := m.Release(ctx, docID); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *LeaseManager) renewLoop() {
+	defer close(m.done)
+	ticker := time.NewTicker(m.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.renewAll()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *LeaseManager) renewAll() {
+	m.mu.Lock()
+	snapshot := make(map[string]heldLease, len(m.held))
+	for docID, lease := range m.held {
+		snapshot[docID] = lease
+	}
+	m.mu.Unlock()
+
+	for docID, lease := range snapshot {
+		ctx, cancel := context.WithTimeout(context.Background(), m.ttl/3)
+		start := time.Now()
+		n, err := renewLeaseScript.Run(ctx, m.rdb, []string{leaseKey(docID)}, m.instanceID, lease.token, m.ttl.Milliseconds()).Int()
+		cancel()
+		switch {
+		case err == nil && n == 1:
+			m.mu.Lock()
+			if cur, ok := m.held[docID]; ok && cur.token == lease.token {
+				m.held[docID] = heldLease{token: lease.token, expiresAt: start.Add(m.ttl)}
+			}
+			m.mu.Unlock()
+		case err != nil && time.Now().Before(lease.expiresAt):
+			// Redis 暂时不可用，租约尚未过期，下一轮再试
+			log.Printf("renew lease error doc=%s: %v", docID, err)
+		default:
+			m.lost(docID, lease.token)
+		}
+	}
+}
+
+func (m *LeaseManager) lost(docID string, token uint64) {
+	m.mu.Lock()
+	cur, ok := m.held[docID]
+	if ok && cur.token == token {
+		delete(m.held, docID)
+	}
+	onLost := m.onLost
+	m.mu.Unlock()
+	if ok && onLost != nil {
+		onLost(docID)
+	}
+}
+
+func (m *LeaseManager) forget(docID string) {
+	m.mu.Lock()
+	_, ok := m.held[docID]
+	delete(m.held, docID)
+	onLost := m.onLost
+	m.mu.Unlock()
+	if ok && onLost != nil {
+		onLost(docID)
+	}
+}
+
+func parseLeaseReply(res []interface{}) (bool, string, uint64, error) {
+	if len(res) != 3 {
+		return false, "", 0, fmt.Errorf("unexpected lease reply: %v", res)
+	}
+	acquired, _ := res[0].(int64)
+	owner, _ := res[1].(string)
+	var token uint64
+	if s, ok := res[2].(string); ok {
+		token, _ = strconv.ParseUint(s, 10, 64)
+	}
+	return acquired == 1, owner, token, nil
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+func newLeaseTestRedis(t *testing.T) (redis.UniversalClient, string) {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	// 若 Redis 未启动则跳过
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("skip: redis not available: %v", err)
+	}
+	docID := "lease-test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	t.Cleanup(func() {
+		rdb.Del(context.Background(), leaseKey(docID), fenceKey(docID))
+		rdb.Close()
+	})
+	return rdb, docID
+}
+
+func TestLeaseManager_HandoverIncrementsToken(t *testing.T) {
+	rdb, docID := newLeaseTestRedis(t)
+	ctx := context.Background()
+	a := NewLeaseManager(rdb, "a:3002", 5*time.Second)
+	b := NewLeaseManager(rdb, "b:3002", 5*time.Second)
+	defer a.Close(ctx)
+	defer b.Close(ctx)
+
+	owner, tokenA, ok, err := a.Ensure(ctx, docID)
+	if err != nil || !ok || owner != "a:3002" {
+		t.Fatalf("a.Ensure() = %s, %d, %v, %v; want owned by a", owner, tokenA, ok, err)
+	}
+	if owner, _, ok, err := b.Ensure(ctx, docID); err != nil || ok || owner != "a:3002" {
+		t.Fatalf("b.Ensure() = %s, %v, %v; want redirect to a", owner, ok, err)
+	}
+
+	if err := a.Release(ctx, docID); err != nil {
+		t.Fatalf("a.Release() error = %v", err)
+	}
+	_, tokenB, ok, err := b.Ensure(ctx, docID)
+	if err != nil || !ok || tokenB <= tokenA {
+		t.Fatalf("b.Ensure() after release = %d, %v, %v; want owned with token > %d", tokenB, ok, err, tokenA)
+	}
+}
+
+func TestLeaseManager_EnsureNoticesTakeoverImmediately(t *testing.T) {
+	rdb, docID := newLeaseTestRedis(t)
+	ctx := context.Background()
+	a := NewLeaseManager(rdb, "a:3002", 5*time.Second)
+	b := NewLeaseManager(rdb, "b:3002", 5*time.Second)
+	defer a.Close(ctx)
+	defer b.Close(ctx)
+
+	lost := make(chan string, 1)
+	a.OnLost(func(docID string) { lost <- docID })
+	if _, _, ok, err := a.Ensure(ctx, docID); err != nil || !ok {
+		t.Fatalf("a.Ensure() = %v, %v; want owned", ok, err)
+	}
+
+	// 租约在 a 不知情时过期并被 b 接管：a 本地记录仍在有效期内，下一次 Ensure 也要发现
+	rdb.Del(ctx, leaseKey(docID))
+	if _, _, ok, err := b.Ensure(ctx, docID); err != nil || !ok {
+		t.Fatalf("b.Ensure() = %v, %v; want owned", ok, err)
+	}
+	if owner, _, ok, err := a.Ensure(ctx, docID); err != nil || ok || owner != "b:3002" {
+		t.Fatalf("a.Ensure() after takeover = %s, %v, %v; want redirect to b", owner, ok, err)
+	}
+	select {
+	case got := <-lost:
+		if got != docID {
+			t.Fatalf("OnLost(%s), want %s", got, docID)
+		}
+	default:
+		t.Fatal("OnLost not called after takeover")
+	}
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// RoomBus：房间消息的跨实例总线。
+// 同一文档的用户可能连在不同的 collab 实例上，每个实例把本地广播发布到文档频道，
+// 并订阅本地有连接的文档频道，把其他实例的消息投递给本地连接。
+type RoomBus interface {
+	Publish(ctx context.Context, docID string, payload []byte) error
+	// Subscribe 订阅 docID 的频道，收到的每条消息交给 handler；返回取消订阅的函数
+	Subscribe(ctx context.Context, docID string, handler func(payload []byte)) (unsubscribe func() error, err error)
+}
+
+// 具体实现：基于 Redis sharded pub/sub（SPUBLISH / SSUBSCRIBE）。
+// 频道带 {docID} hash tag，集群下消息只在该文档所在分片内传播，不会广播到全部节点。
+// 每个 Redis 节点只保持一条共享的订阅连接，文档频道在上面 SSUBSCRIBE / SUNSUBSCRIBE，
+// 收到的消息按频道分发给各自的 handler；断线重连后由 go-redis 自动重新订阅。
+type redisRoomBus struct {
+	rdb redis.UniversalClient
+
+	mu       sync.Mutex
+	shards   map[string]*redis.PubSub // Redis 节点地址 -> 共享订阅连接
+	handlers map[string]func([]byte)  // 频道 -> handler
+}
+
+func NewRedisRoomBus(rdb redis.UniversalClient) RoomBus {
+	return &redisRoomBus{rdb: rdb, shards: make(map[string]*redis.PubSub), handlers: make(map[string]func([]byte))}
+}
+
+func (b *redisRoomBus) Publish(ctx context.Context, docID string, payload []byte) error {
+	return b.rdb.SPublish(ctx, roomChannel(docID), payload).Err()
+}
+
+// Subscribe 在频道所在节点的共享连接上发送 SSUBSCRIBE，不等待确认：
+// 订阅生效前发布的消息会错过，客户端按 revision 缺口发 sync 追平
+func (b *redisRoomBus) Subscribe(ctx context.Context, docID string, handler func(payload []byte)) (func() error, error) {
+	channel := roomChannel(docID)
+	ps, err := b.shard(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.handlers[channel] = handler
+	b.mu.Unlock()
+	if err := ps.SSubscribe(ctx, channel); err != nil {
+		b.removeHandler(channel)
+		return nil, err
+	}
+	return func() error {
+		b.removeHandler(channel)
+		return ps.SUnsubscribe(context.Background(), channel)
+	}, nil
+}
+
+func (b *redisRoomBus) removeHandler(channel string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.handlers, channel)
+}
+
+// 频道所在节点的共享订阅连接，第一次用到时创建
+func (b *redisRoomBus) shard(ctx context.Context, channel string) (*redis.PubSub, error) {
+	var node redis.UniversalClient = b.rdb
+	addr := ""
+	if cluster, ok := b.rdb.(*redis.ClusterClient); ok {
+		master, err := cluster.MasterForKey(ctx, channel)
+		if err != nil {
+			return nil, err
+		}
+		node, addr = master, master.Options().Addr
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ps, ok := b.shards[addr]; ok {
+		return ps, nil
+	}
+	ps := node.SSubscribe(ctx)
+	b.shards[addr] = ps
+	go b.dispatch(addr, ps)
+	return ps, nil
+}
+
+func (b *redisRoomBus) dispatch(addr string, ps *redis.PubSub) {
+	// Close 后 Channel 会被关闭，循环随之退出
+	for msg := range ps.Channel() {
+		b.mu.Lock()
+		handler := b.handlers[msg.Channel]
+		b.mu.Unlock()
+		if handler != nil {
+			handler([]byte(msg.Payload))
+		}
+	}
+	log.Printf("room bus connection closed node=%s", addr)
+}
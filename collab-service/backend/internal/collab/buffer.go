@@ -7,8 +7,12 @@ import (
 // 抽象文档内容缓冲区接口
 type Buffer interface {
 	Len() int
+	// Apply 必须先按 Len() 校验整个 delta（越界 retain/delete、非法 count、空 insert、未知 kind），
+	// 校验失败返回错误且不修改任何内容，保证原子性
 	Apply(d delta.Delta) error
 	String() string
+	// 带样式属性的完整文档（只含 insert 的 delta）
+	Delta() delta.Delta
 }
 
 /*
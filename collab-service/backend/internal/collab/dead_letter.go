@@ -0,0 +1,352 @@
+package collab
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// 死信：重试耗尽或无法投递的事件，连同失败原因一起写入死信 topic（或本地 DLQ 文件）
+type DeadLetter struct {
+	Topic    string          `json:"topic"`              // 原本要投递的 topic
+	Key      string          `json:"key"`                // 原消息 key（docID）
+	Payload  json.RawMessage `json:"payload"`            // 原始事件（DocEvent 的 JSON）
+	Encoding EventEncoding   `json:"encoding,omitempty"` // 原本的消息体编码，重新投递时按它编码
+	Reason   string          `json:"reason"`
+	Attempts int             `json:"attempts"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+// 毒消息：无论重试多少次都不会成功（消息过大、格式非法、无法编码），直接进死信，不再重试也不写溢出队列
+func isPoisonError(err error) bool {
+	var encErr *eventEncodeError
+	return errors.As(err, &encErr) ||
+		errors.Is(err, sarama.ErrMessageSizeTooLarge) ||
+		errors.Is(err, sarama.ErrInvalidMessage) ||
+		errors.Is(err, sarama.ErrInvalidMessageSize) ||
+		errors.Is(err, sarama.ErrInvalidRecord)
+}
+
+type eventEncodeError struct{ err error }
+
+func (e *eventEncodeError) Error() string { return "encode event: " + e.err.Error() }
+func (e *eventEncodeError) Unwrap() error { return e.err }
+
+// 本地 DLQ 文件：每行一个 DeadLetter，只追加
+type deadLetterFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (f *deadLetterFile) append(dl DeadLetter) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	return f.appendLine(b)
+}
+
+// 追加一行原始记录（无法解析的死信原样放回时使用）
+func (f *deadLetterFile) appendLine(b []byte) error {
+	if len(b) > 0 && b[len(b)-1] == '\n' {
+		b = b[:len(b)-1]
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(b, '\n'))
+	return err
+}
+
+func deadLetterMessage(topic string, dl DeadLetter) (*sarama.ProducerMessage, error) {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return nil, err
+	}
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(dl.Key),
+		Value: sarama.ByteEncoder(b),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("reason"), Value: []byte(dl.Reason)},
+		},
+	}, nil
+}
+
+// 重新投递一条死信：按原编码发回原 topic（未记录时用 topic）；负载不是 DocEvent 时原样发送
+func redriveMessage(dl DeadLetter, topic string) *sarama.ProducerMessage {
+	if dl.Topic != "" {
+		topic = dl.Topic
+	}
+	var evt DocEvent
+	if err := json.Unmarshal(dl.Payload, &evt); err == nil && evt.EventID != "" {
+		enc := dl.Encoding
+		if enc == "" {
+			enc = EncodingJSON
+		}
+		if msg, err := eventMessage(topic, evt, enc); err == nil {
+			return msg
+		}
+	}
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(dl.Payload)}
+	if dl.Key != "" {
+		msg.Key = sarama.StringEncoder(dl.Key)
+	}
+	return msg
+}
+
+// 重新投递时每条死信的发送次数与间隔，仍然失败的放回死信队列末尾，不挡住后面的死信
+const redriveAttempts = 3
+
+var redriveBackoff = 200 * time.Millisecond
+
+// RedriveResult 一次重新投递的结果：Redriven 为发回原 topic 的条数，
+// Parked 为多次发送仍失败（或无法解析）、重新放回死信队列留给下一次的条数
+type RedriveResult struct {
+	Redriven int
+	Parked   int
+}
+
+func (r *RedriveResult) add(o RedriveResult) {
+	r.Redriven += o.Redriven
+	r.Parked += o.Parked
+}
+
+// 发送一条死信，失败时重试到 redriveAttempts 次
+func redriveOne(producer sarama.SyncProducer, dl DeadLetter, topic string) error {
+	var err error
+	for attempt := 0; attempt < redriveAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(redriveBackoff)
+		}
+		if _, _, err = producer.SendMessage(redriveMessage(dl, topic)); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// 重新放回死信队列的记录：累计发送次数，原因与时间换成这一次的
+func reparked(dl DeadLetter, err error) DeadLetter {
+	dl.Attempts += redriveAttempts
+	dl.Reason = err.Error()
+	dl.FailedAt = time.Now()
+	return dl
+}
+
+// RedriveDeadLetterFile 把本地 DLQ 文件中的事件重新发到原 topic。
+// 先把文件改名为 <path>.redriving 再处理，服务继续追加的死信写入新的 <path>，互不覆盖；
+// 多次发送仍失败的（以及无法解析的）记录追加回 <path>，留给下一次执行，不挡住后面的死信。
+// 上次中途退出留下的 .redriving 先处理。
+func RedriveDeadLetterFile(path string, producer sarama.SyncProducer, topic string) (RedriveResult, error) {
+	work := path + ".redriving"
+	dlq := &deadLetterFile{path: path}
+	var total RedriveResult
+	if _, err := os.Stat(work); err == nil {
+		r, err := redriveFile(work, dlq, producer, topic)
+		total.add(r)
+		if err != nil {
+			return total, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return total, err
+	}
+
+	if err := os.Rename(path, work); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return total, nil
+		}
+		return total, err
+	}
+	r, err := redriveFile(work, dlq, producer, topic)
+	total.add(r)
+	return total, err
+}
+
+// 处理一个已经轮转出来的 DLQ 文件，处理完删除；放回 dlq 失败时保留还没处理的记录，返回错误
+func redriveFile(path string, dlq *deadLetterFile, producer sarama.SyncProducer, topic string) (RedriveResult, error) {
+	var res RedriveResult
+	f, err := os.Open(path)
+	if err != nil {
+		return res, err
+	}
+	var remaining [][]byte
+	var parkErr error
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if parkErr != nil {
+				// 放不回死信队列（磁盘问题），剩下的原样保留
+				remaining = append(remaining, line)
+			} else if sent, perr := redriveLine(line, dlq, producer, topic); perr != nil {
+				parkErr = perr
+				remaining = append(remaining, line)
+			} else if sent {
+				res.Redriven++
+			} else {
+				res.Parked++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return res, err
+		}
+	}
+	f.Close()
+
+	if len(remaining) == 0 {
+		return res, os.Remove(path)
+	}
+	// 先写临时文件再替换，避免中途崩溃丢失记录
+	tmp := path + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return res, err
+	}
+	for _, line := range remaining {
+		if len(line) > 0 && line[len(line)-1] != '\n' {
+			line = append(line, '\n')
+		}
+		if _, err := out.Write(line); err != nil {
+			out.Close()
+			return res, err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return res, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return res, err
+	}
+	return res, fmt.Errorf("park dead letter: %w", parkErr)
+}
+
+// 投递一行死信，返回是否发送成功；多次失败或无法解析时放回 dlq，放回失败时返回错误
+func redriveLine(line []byte, dlq *deadLetterFile, producer sarama.SyncProducer, topic string) (bool, error) {
+	var dl DeadLetter
+	if err := json.Unmarshal(line, &dl); err != nil {
+		return false, dlq.appendLine(line)
+	}
+	sendErr := redriveOne(producer, dl, topic)
+	if sendErr == nil {
+		return true, nil
+	}
+	log.Printf("redrive doc=%s failed after %d attempts, parked: %v", dl.Key, redriveAttempts, sendErr)
+	return false, dlq.append(reparked(dl, sendErr))
+}
+
+// RedriveDeadLetterTopic 把死信 topic 中的事件重新发到原 topic。
+// 进度以 group 的消费位点保存，重复执行只处理上次之后新增的死信；
+// 只处理到开始时的末尾位置，过程中新进来的死信留给下一次；首次执行从最早的死信开始。
+// 多次发送仍失败的死信带着累计次数重新写回死信 topic，留给下一次执行。
+func RedriveDeadLetterTopic(ctx context.Context, client sarama.Client, group, dlqTopic string, producer sarama.SyncProducer, topic string) (RedriveResult, error) {
+	var total RedriveResult
+	partitions, err := client.Partitions(dlqTopic)
+	if err != nil {
+		return total, err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return total, err
+	}
+	defer consumer.Close()
+	om, err := sarama.NewOffsetManagerFromClient(group, client)
+	if err != nil {
+		return total, err
+	}
+	defer om.Close()
+
+	for _, p := range partitions {
+		r, err := redrivePartition(ctx, client, consumer, om, dlqTopic, p, producer, topic)
+		total.add(r)
+		if err != nil {
+			om.Commit()
+			return total, fmt.Errorf("partition %d: %w", p, err)
+		}
+	}
+	om.Commit()
+	return total, nil
+}
+
+func redrivePartition(ctx context.Context, client sarama.Client, consumer sarama.Consumer, om sarama.OffsetManager,
+	dlqTopic string, partition int32, producer sarama.SyncProducer, topic string) (RedriveResult, error) {
+	var res RedriveResult
+	end, err := client.GetOffset(dlqTopic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return res, err
+	}
+	pom, err := om.ManagePartition(dlqTopic, partition)
+	if err != nil {
+		return res, err
+	}
+	defer pom.Close()
+
+	start, _ := pom.NextOffset()
+	if start < 0 {
+		// 没有提交过位点
+		if start, err = client.GetOffset(dlqTopic, partition, sarama.OffsetOldest); err != nil {
+			return res, err
+		}
+	}
+	if start >= end {
+		return res, nil
+	}
+	pc, err := consumer.ConsumePartition(dlqTopic, partition, start)
+	if err != nil {
+		return res, err
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case msg := <-pc.Messages():
+			var dl DeadLetter
+			if err := json.Unmarshal(msg.Value, &dl); err != nil {
+				// 不是本服务写入的死信，跳过
+				log.Printf("skip malformed dead letter partition=%d offset=%d: %v", partition, msg.Offset, err)
+			} else if sendErr := redriveOne(producer, dl, topic); sendErr != nil {
+				// 放回死信 topic 末尾（在本次的结束位置之后），放不回时停在这条，下次从这里继续
+				log.Printf("redrive doc=%s failed after %d attempts, parked: %v", dl.Key, redriveAttempts, sendErr)
+				parked, err := deadLetterMessage(dlqTopic, reparked(dl, sendErr))
+				if err == nil {
+					_, _, err = producer.SendMessage(parked)
+				}
+				if err != nil {
+					return res, fmt.Errorf("park dead letter: %w", err)
+				}
+				res.Parked++
+			} else {
+				res.Redriven++
+			}
+			pom.MarkOffset(msg.Offset+1, "")
+			if msg.Offset+1 >= end {
+				return res, nil
+			}
+		case err := <-pc.Errors():
+			return res, err
+		case <-ctx.Done():
+			return res, ctx.Err()
+		}
+	}
+}
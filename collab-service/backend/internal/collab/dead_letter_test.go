@@ -0,0 +1,193 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// 对指定 topic（或消息 key）返回固定错误的假 producer，其余记录下来
+type recordingProducer struct {
+	sarama.SyncProducer
+	failTopic map[string]error
+	failKey   map[string]error
+	sent      []*sarama.ProducerMessage
+}
+
+func (p *recordingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if err := p.failTopic[msg.Topic]; err != nil {
+		return 0, 0, err
+	}
+	if msg.Key != nil {
+		key, _ := msg.Key.Encode()
+		if err := p.failKey[string(key)]; err != nil {
+			return 0, 0, err
+		}
+	}
+	p.sent = append(p.sent, msg)
+	return 0, 0, nil
+}
+
+func TestKafkaDispatcher_PoisonEventGoesToDeadLetter(t *testing.T) {
+	producer := &recordingProducer{failTopic: map[string]error{"doc-ops": sarama.ErrMessageSizeTooLarge}}
+	d := NewKafkaDispatcher(producer, "doc-ops", nil, KafkaDispatcherOptions{
+		QueueSize: 4, Workers: 1, MaxRetry: 3, DeadLetterTopic: "doc-ops-dlq",
+	})
+	if err := d.Enqueue(context.Background(), opEvent("doc", 7)); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	stats, _ := d.Stop(context.Background())
+	if stats.DeadLettered != 1 || len(producer.sent) != 1 {
+		t.Fatalf("Stats() = %+v, sent %d; want 1 dead letter", stats, len(producer.sent))
+	}
+
+	// 毒消息不重试，死信带上原因、次数与原始事件
+	var dl DeadLetter
+	b, _ := producer.sent[0].Value.Encode()
+	if err := json.Unmarshal(b, &dl); err != nil {
+		t.Fatalf("unmarshal dead letter: %v", err)
+	}
+	var evt DocEvent
+	_ = json.Unmarshal(dl.Payload, &evt)
+	if producer.sent[0].Topic != "doc-ops-dlq" || dl.Attempts != 1 || dl.Reason == "" || eventRevision(evt) != 7 {
+		t.Fatalf("dead letter = %+v (payload %+v), want attempts 1 with reason and original event", dl, evt)
+	}
+}
+
+func TestKafkaDispatcher_DeadLetterFallsBackToFileAndRedrives(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	producer := &recordingProducer{failTopic: map[string]error{
+		"doc-ops":     sarama.ErrInvalidMessage,
+		"doc-ops-dlq": sarama.ErrOutOfBrokers,
+	}}
+	d := NewKafkaDispatcher(producer, "doc-ops", nil, KafkaDispatcherOptions{
+		QueueSize: 4, Workers: 1, DeadLetterTopic: "doc-ops-dlq", DeadLetterFile: path,
+	})
+	for i := 1; i <= 2; i++ {
+		if err := d.Enqueue(context.Background(), opEvent("doc", uint64(i))); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	if stats, _ := d.Stop(context.Background()); stats.DeadLetteredLocal != 2 {
+		t.Fatalf("Stats() = %+v, want 2 local dead letters", stats)
+	}
+
+	// 问题修复后重新投递，文件清空
+	producer.failTopic = nil
+	res, err := RedriveDeadLetterFile(path, producer, "doc-ops")
+	if err != nil || res.Redriven != 2 || res.Parked != 0 {
+		t.Fatalf("RedriveDeadLetterFile() = %+v, %v; want 2 redriven, nil", res, err)
+	}
+	if producer.sent[0].Topic != "doc-ops" {
+		t.Fatalf("redriven to %s, want doc-ops", producer.sent[0].Topic)
+	}
+	if b, _ := os.ReadFile(path); len(b) != 0 {
+		t.Fatalf("dlq file still has %q", b)
+	}
+}
+
+// 重新投递时不等待重试间隔
+func noRedriveBackoff(t *testing.T) {
+	prev := redriveBackoff
+	redriveBackoff = 0
+	t.Cleanup(func() { redriveBackoff = prev })
+}
+
+// 读出 DLQ 文件里的死信，无法解析的行记为空 DeadLetter
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read dlq file: %v", err)
+	}
+	var out []DeadLetter
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var dl DeadLetter
+		_ = json.Unmarshal([]byte(line), &dl)
+		out = append(out, dl)
+	}
+	return out
+}
+
+func TestRedriveDeadLetterFile_ParksFailedAndKeepsOrder(t *testing.T) {
+	noRedriveBackoff(t)
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	f := &deadLetterFile{path: path}
+	appendDL := func(rev uint64) {
+		payload, _ := json.Marshal(opEvent("doc", rev))
+		if err := f.append(DeadLetter{Topic: "doc-ops", Key: "doc", Payload: payload, Attempts: 1}); err != nil {
+			t.Fatalf("append() error = %v", err)
+		}
+	}
+	appendDL(1)
+	appendDL(2)
+
+	// Kafka 仍不可用：记录带着累计次数按原顺序放回 DLQ 文件，服务之后追加的死信排在后面
+	producer := &recordingProducer{failTopic: map[string]error{"doc-ops": sarama.ErrOutOfBrokers}}
+	if res, err := RedriveDeadLetterFile(path, producer, "doc-ops"); err != nil || res.Redriven != 0 || res.Parked != 2 {
+		t.Fatalf("RedriveDeadLetterFile() = %+v, %v; want 2 parked, nil", res, err)
+	}
+	for _, dl := range readDeadLetters(t, path) {
+		if dl.Attempts != 1+redriveAttempts || dl.Reason == "" {
+			t.Fatalf("parked dead letter = %+v, want attempts %d with reason", dl, 1+redriveAttempts)
+		}
+	}
+	appendDL(3)
+
+	producer.failTopic = nil
+	if res, err := RedriveDeadLetterFile(path, producer, "doc-ops"); err != nil || res.Redriven != 3 || res.Parked != 0 {
+		t.Fatalf("RedriveDeadLetterFile() = %+v, %v; want 3 redriven, nil", res, err)
+	}
+	for i, msg := range producer.sent {
+		b, _ := msg.Value.Encode()
+		var evt DocEvent
+		_ = json.Unmarshal(b, &evt)
+		if eventRevision(evt) != uint64(i+1) {
+			t.Fatalf("redrive #%d sent revision %d, want %d", i, eventRevision(evt), i+1)
+		}
+	}
+	for _, p := range []string{path, path + ".redriving"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s still exists after redrive (err = %v)", p, err)
+		}
+	}
+}
+
+func TestRedriveDeadLetterFile_BadRecordDoesNotBlockOthers(t *testing.T) {
+	noRedriveBackoff(t)
+	path := filepath.Join(t.TempDir(), "dlq.jsonl")
+	f := &deadLetterFile{path: path}
+	for i, key := range []string{"doc1", "doc2", "doc3"} {
+		payload, _ := json.Marshal(opEvent(key, uint64(i+1)))
+		if err := f.append(DeadLetter{Topic: "doc-ops", Key: key, Payload: payload}); err != nil {
+			t.Fatalf("append() error = %v", err)
+		}
+	}
+	if err := f.appendLine([]byte("not a dead letter")); err != nil {
+		t.Fatalf("appendLine() error = %v", err)
+	}
+
+	// doc2 一直发不出去：放回 DLQ 后继续处理 doc3，无法解析的行也原样放回
+	producer := &recordingProducer{failKey: map[string]error{"doc2": sarama.ErrMessageSizeTooLarge}}
+	res, err := RedriveDeadLetterFile(path, producer, "doc-ops")
+	if err != nil || res.Redriven != 2 || res.Parked != 2 {
+		t.Fatalf("RedriveDeadLetterFile() = %+v, %v; want 2 redriven, 2 parked", res, err)
+	}
+	if len(producer.sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(producer.sent))
+	}
+	for i, want := range []string{"doc1", "doc3"} {
+		if key, _ := producer.sent[i].Key.Encode(); string(key) != want {
+			t.Fatalf("redrive #%d key = %s, want %s", i, key, want)
+		}
+	}
+	parked := readDeadLetters(t, path)
+	if len(parked) != 2 || parked[0].Key != "doc2" || parked[0].Attempts != redriveAttempts || parked[1].Key != "" {
+		t.Fatalf("parked = %+v, want doc2 then the malformed line", parked)
+	}
+}
@@ -0,0 +1,187 @@
+package collab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 事件在 Kafka 消息体中的编码
+type EventEncoding string
+
+const (
+	EncodingJSON     EventEncoding = "json"
+	EncodingProtobuf EventEncoding = "protobuf" // 结构见 doc_event.proto
+)
+
+func ParseEventEncoding(s string) (EventEncoding, error) {
+	switch EventEncoding(s) {
+	case "", EncodingJSON:
+		return EncodingJSON, nil
+	case EncodingProtobuf:
+		return EncodingProtobuf, nil
+	}
+	return "", fmt.Errorf("unknown event encoding %q", s)
+}
+
+// 构造发往 topic 的消息：key 为 docID（同一文档落到同一分区），
+// 头部带上 eventId / eventType / schemaVersion / contentType，消费方不解析消息体也能路由与去重
+func eventMessage(topic string, evt DocEvent, enc EventEncoding) (*sarama.ProducerMessage, error) {
+	b, err := EncodeEvent(evt, enc)
+	if err != nil {
+		return nil, err
+	}
+	return &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(evt.DocID),
+		Value: sarama.ByteEncoder(b),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("eventId"), Value: []byte(evt.EventID)},
+			{Key: []byte("eventType"), Value: []byte(evt.EventType)},
+			{Key: []byte("schemaVersion"), Value: []byte(strconv.Itoa(evt.SchemaVersion))},
+			{Key: []byte("contentType"), Value: []byte(enc.ContentType())},
+		},
+	}, nil
+}
+
+// EncodeEvent 按 enc 编码事件，失败时返回的错误属于毒消息（重试也不会成功）
+func EncodeEvent(evt DocEvent, enc EventEncoding) ([]byte, error) {
+	var b []byte
+	var err error
+	if enc == EncodingProtobuf {
+		b, err = marshalEventProto(evt)
+	} else {
+		b, err = json.Marshal(evt)
+	}
+	if err != nil {
+		return nil, &eventEncodeError{err: err}
+	}
+	return b, nil
+}
+
+// ContentType 编码对应的 MIME 类型，写入消息头供消费方选择解码方式
+func (e EventEncoding) ContentType() string {
+	if e == EncodingProtobuf {
+		return "application/x-protobuf"
+	}
+	return "application/json"
+}
+
+// protobuf 编码（手写 wire format，对应 doc_event.proto 中的 DocEvent）
+func marshalEventProto(evt DocEvent) ([]byte, error) {
+	var b []byte
+	b = appendVarintField(b, 1, uint64(evt.SchemaVersion))
+	b = appendStringField(b, 2, evt.EventID)
+	b = appendStringField(b, 3, evt.EventType)
+	b = appendTimestampField(b, 4, evt.OccurredAt)
+	b = appendStringField(b, 5, evt.DocID)
+
+	payload, field, err := marshalPayloadProto(evt.EventType, evt.Payload)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, payload), nil
+}
+
+func marshalPayloadProto(eventType string, raw json.RawMessage) ([]byte, protowire.Number, error) {
+	var b []byte
+	switch eventType {
+	case EventDocumentCreated:
+		var p DocumentCreatedPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, 0, err
+		}
+		b = appendVarintField(b, 1, p.OwnerID)
+		return appendStringField(b, 2, p.Title), 10, nil
+	case EventDocumentRenamed:
+		var p DocumentRenamedPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, 0, err
+		}
+		return appendStringField(b, 1, p.Title), 11, nil
+	case EventDocumentArchived:
+		var p DocumentArchivedPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, 0, err
+		}
+		return appendVarintField(b, 1, p.ArchivedBy), 12, nil
+	case EventOpApplied:
+		var p OpAppliedPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, 0, err
+		}
+		ops, err := json.Marshal(p.Ops)
+		if err != nil {
+			return nil, 0, err
+		}
+		b = appendStringField(b, 1, p.OperationID)
+		b = appendVarintField(b, 2, p.Revision)
+		b = appendVarintField(b, 3, p.AuthorID)
+		b = appendStringField(b, 4, p.ClientID)
+		b = appendVarintField(b, 5, p.ClientSeq)
+		b = appendVarintField(b, 6, p.BaseRevision)
+		b = appendStringField(b, 7, string(ops))
+		return appendTimestampField(b, 8, p.AppliedAt), 13, nil
+	case EventSnapshotSaved:
+		var p SnapshotSavedPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, 0, err
+		}
+		return appendVarintField(b, 1, p.Revision), 14, nil
+	case EventSnapshotRestored:
+		var p SnapshotRestoredPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, 0, err
+		}
+		b = appendVarintField(b, 1, p.RestoredRevision)
+		b = appendVarintField(b, 2, p.Revision)
+		b = appendStringField(b, 3, p.OperationID)
+		return appendVarintField(b, 4, p.AuthorID), 15, nil
+	case EventMemberJoined, EventMemberLeft:
+		var p MemberPayload
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return nil, 0, err
+		}
+		b = appendVarintField(b, 1, p.UserID)
+		b = appendStringField(b, 2, p.Username)
+		if eventType == EventMemberJoined {
+			return b, 16, nil
+		}
+		return b, 17, nil
+	}
+	return nil, 0, fmt.Errorf("no protobuf mapping for event type %q", eventType)
+}
+
+// proto3 默认值不写入
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendStringField(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// google.protobuf.Timestamp{seconds = 1, nanos = 2}
+func appendTimestampField(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var ts []byte
+	ts = appendVarintField(ts, 1, uint64(t.Unix()))
+	ts = appendVarintField(ts, 2, uint64(t.Nanosecond()))
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
@@ -0,0 +1,61 @@
+package collab
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 逐个读出消息中的顶层字段：varint 字段返回数值，bytes 字段返回内容
+func protoFields(t *testing.T, b []byte) map[protowire.Number]any {
+	t.Helper()
+	fields := make(map[protowire.Number]any)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			fields[num], b = v, b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			fields[num], b = v, b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d for field %d", typ, num)
+		}
+	}
+	return fields
+}
+
+func TestEventMessage_ProtobufEncoding(t *testing.T) {
+	evt, err := NewDocEvent("42", EventMemberJoined, "doc", time.Unix(1700000000, 0), MemberPayload{UserID: 7, Username: "alice"})
+	if err != nil {
+		t.Fatalf("NewDocEvent() error = %v", err)
+	}
+	msg, err := eventMessage("doc-ops", evt, EncodingProtobuf)
+	if err != nil {
+		t.Fatalf("eventMessage() error = %v", err)
+	}
+	b, _ := msg.Value.Encode()
+	fields := protoFields(t, b)
+	if fields[1] != uint64(EventSchemaVersion) || string(fields[2].([]byte)) != "42" || string(fields[5].([]byte)) != "doc" {
+		t.Fatalf("envelope fields = %v", fields)
+	}
+	member := protoFields(t, fields[16].([]byte))
+	if member[1] != uint64(7) || string(member[2].([]byte)) != "alice" {
+		t.Fatalf("member_joined payload = %v, want user 7 alice", member)
+	}
+	if got := string(msg.Headers[len(msg.Headers)-1].Value); got != "application/x-protobuf" {
+		t.Fatalf("contentType header = %q", got)
+	}
+
+	// 没有 protobuf 映射的事件类型属于毒消息
+	evt.EventType = "UNKNOWN"
+	if _, err := eventMessage("doc-ops", evt, EncodingProtobuf); !isPoisonError(err) {
+		t.Fatalf("eventMessage(UNKNOWN) error = %v, want encode error", err)
+	}
+}
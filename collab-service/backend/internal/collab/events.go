@@ -0,0 +1,93 @@
+package collab
+
+import (
+	"encoding/json"
+	"time"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+// 文档事件的信封格式版本。只增加字段 / 事件类型时不升级；字段语义变化或删除字段时 +1，
+// 消费方按 schemaVersion 选择解析方式。
+const EventSchemaVersion = 1
+
+// 事件类型
+const (
+	EventDocumentCreated  = "DOCUMENT_CREATED"
+	EventDocumentRenamed  = "DOCUMENT_RENAMED"  // 预留：目前还没有重命名接口
+	EventDocumentArchived = "DOCUMENT_ARCHIVED" // 预留：目前还没有归档接口
+	EventOpApplied        = "OP_APPLIED"
+	EventSnapshotSaved    = "SNAPSHOT_SAVED"
+	EventSnapshotRestored = "SNAPSHOT_RESTORED"
+	EventMemberJoined     = "MEMBER_JOINED"
+	EventMemberLeft       = "MEMBER_LEFT"
+)
+
+// DocEvent 发往 Kafka 的文档事件信封，payload 的结构由 eventType 决定（见下面的 *Payload 类型）。
+// 内部（内存队列、溢出段文件、outbox、死信）始终以 JSON 保存，发送时再按配置编码为 JSON 或 protobuf。
+type DocEvent struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	EventID       string          `json:"eventId"` // 全局唯一，消费方据此去重；OP_APPLIED 与操作 ID 相同
+	EventType     string          `json:"eventType"`
+	OccurredAt    time.Time       `json:"occurredAt"`
+	DocID         string          `json:"docId"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+type DocumentCreatedPayload struct {
+	OwnerID uint64 `json:"ownerId"`
+	Title   string `json:"title"`
+}
+
+type DocumentRenamedPayload struct {
+	Title string `json:"title"`
+}
+
+type DocumentArchivedPayload struct {
+	ArchivedBy uint64 `json:"archivedBy"`
+}
+
+type OpAppliedPayload struct {
+	OperationID  string      `json:"operationId"`
+	Revision     uint64      `json:"revision"`
+	AuthorID     uint64      `json:"authorId"`
+	ClientID     string      `json:"clientId"`
+	ClientSeq    uint64      `json:"clientSeq"` // 针对同一个 clientId 的“本地递增序号”
+	BaseRevision uint64      `json:"baseRevision"`
+	Ops          delta.Delta `json:"ops"`
+	AppliedAt    time.Time   `json:"appliedAt"`
+}
+
+// 快照覆盖到 Revision；outbox 模式下该事件可能晚于 revision 更大的 OP_APPLIED 到达
+type SnapshotSavedPayload struct {
+	Revision uint64 `json:"revision"`
+}
+
+// 回滚到快照 RestoredRevision，以新版本 Revision（操作 OperationID）的形式生效
+type SnapshotRestoredPayload struct {
+	RestoredRevision uint64 `json:"restoredRevision"`
+	Revision         uint64 `json:"revision"`
+	OperationID      string `json:"operationId"`
+	AuthorID         uint64 `json:"authorId"`
+}
+
+// 用户在该文档上的第一个连接加入 / 最后一个连接离开
+type MemberPayload struct {
+	UserID   uint64 `json:"userId"`
+	Username string `json:"username,omitempty"`
+}
+
+func NewDocEvent(eventID, eventType, docID string, occurredAt time.Time, payload any) (DocEvent, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return DocEvent{}, err
+	}
+	return DocEvent{
+		SchemaVersion: EventSchemaVersion,
+		EventID:       eventID,
+		EventType:     eventType,
+		OccurredAt:    occurredAt,
+		DocID:         docID,
+		Payload:       b,
+	}, nil
+}
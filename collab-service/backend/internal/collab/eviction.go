@@ -0,0 +1,232 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// 文档淘汰策略，三个条件任一满足即淘汰（为 0 表示不启用该条件）：
+// - IdleTimeout：超过该时间未被访问
+// - MaxDocs：常驻文档数上限，超出时按 LRU 淘汰最久未访问的
+// - MemoryBudget：所有常驻文档估算内存之和上限（字节），超出时同样按 LRU 淘汰
+type EvictionOptions struct {
+	IdleTimeout  time.Duration
+	MaxDocs      int
+	MemoryBudget int64
+}
+
+// 内存文档缓存的统计信息
+type CacheStats struct {
+	ResidentDocs   int    `json:"residentDocs"`
+	EstimatedBytes int64  `json:"estimatedBytes"`
+	Evictions      uint64 `json:"evictions"`
+	Reloads        uint64 `json:"reloads"` // 被淘汰后又重新加载的次数
+}
+
+type residentDoc struct {
+	docID      string
+	ds         *docState
+	lastAccess int64
+	size       int64
+}
+
+// 能报告自身占用内存的缓冲区（PieceTable / PieceTree 都实现了）
+type sizedBuffer interface {
+	memSize() int64
+}
+
+func (pt *PieceTable) memSize() int64 {
+	return int64(len(pt.original)+len(pt.add))*4 + int64(len(pt.pieces))*48
+}
+
+func (t *PieceTree) memSize() int64 {
+	return int64(len(t.original)+len(t.add))*4 + int64(t.nodeCount())*80
+}
+
+func (t *PieceTree) nodeCount() int {
+	n := 0
+	t.walk(t.root, func(piece) { n++ })
+	return n
+}
+
+// 估算单个文档占用的内存：缓冲区 + 环形缓冲中的操作
+func (ds *docState) memSize() int64 {
+	var size int64
+	if sb, ok := ds.buf.(sizedBuffer); ok {
+		size = sb.memSize()
+	} else if ds.buf != nil {
+		size = int64(ds.buf.Len()) * 4
+	}
+	for _, op := range ds.opsRing {
+		size += 128
+		for _, o := range op.Ops {
+			size += int64(len(o.Text))
+		}
+	}
+	return size
+}
+
+func (s *InMemoryService) residentDocs() []residentDoc {
+	docs := s.liveDocs()
+	out := make([]residentDoc, 0, len(docs))
+	for id, ds := range docs {
+		var size int64
+		if !ds.peek(func(ds *docState) { size = ds.memSize() }) {
+			continue
+		}
+		out = append(out, residentDoc{docID: id, ds: ds, lastAccess: ds.lastAccess.Load(), size: size})
+	}
+	return out
+}
+
+func (s *InMemoryService) CacheStats() CacheStats {
+	docs := s.residentDocs()
+	stats := CacheStats{
+		ResidentDocs: len(docs),
+		Evictions:    s.evictions.Load(),
+		Reloads:      s.reloads.Load(),
+	}
+	for _, d := range docs {
+		stats.EstimatedBytes += d.size
+	}
+	return stats
+}
+
+func (s *InMemoryService) EvictIdle(ctx context.Context, opt EvictionOptions) (int, error) {
+	docs := s.residentDocs()
+	// 最久未访问的排在前面
+	sort.Slice(docs, func(i, j int) bool { return docs[i].lastAccess < docs[j].lastAccess })
+
+	var total int64
+	for _, d := range docs {
+		total += d.size
+	}
+	now := time.Now().UnixNano()
+	remaining := len(docs)
+
+	evicted := 0
+	var errs []error
+	for _, d := range docs {
+		idle := opt.IdleTimeout > 0 && now-d.lastAccess >= int64(opt.IdleTimeout)
+		overDocs := opt.MaxDocs > 0 && remaining > opt.MaxDocs
+		overMem := opt.MemoryBudget > 0 && total > opt.MemoryBudget
+		if !idle && !overDocs && !overMem {
+			// 按 LRU 排序，后面的文档更新，不会再满足空闲条件；预算也已满足
+			break
+		}
+		ok, err := s.evictDoc(ctx, d)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			evicted++
+			remaining--
+			total -= d.size
+		}
+	}
+	return evicted, errors.Join(errs...)
+}
+
+// 先快照再移出内存。快照期间文档被访问或修改时放弃本次淘汰。
+func (s *InMemoryService) evictDoc(ctx context.Context, d residentDoc) (bool, error) {
+	ds := d.ds
+	var dirty bool
+	err := ds.do(ctx, func(ds *docState) error {
+		dirty = ds.revision > ds.snapshotRev
+		return nil
+	})
+	if errors.Is(err, errDocRetired) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if dirty {
+		if s.store == nil {
+			// 没有快照存储时淘汰会丢数据
+			return false, nil
+		}
+		if err := s.saveDocSnapshot(ctx, d.docID, ds); err != nil {
+			return false, err
+		}
+	}
+
+	// 最后的检查与移除作为一条命令执行：之后排队的命令会拿到 errDocRetired 并重新加载文档
+	evicted := false
+	err = ds.do(ctx, func(ds *docState) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.docs[d.docID] != ds || ds.revision != ds.snapshotRev || ds.lastAccess.Load() != d.lastAccess {
+			return nil
+		}
+		delete(s.docs, d.docID)
+		ds.retire()
+		evicted = true
+		return nil
+	})
+	if errors.Is(err, errDocRetired) {
+		return false, nil
+	}
+	if err != nil || !evicted {
+		return false, err
+	}
+	s.evictions.Add(1)
+
+	s.evictedMu.Lock()
+	s.evictedDocs[d.docID] = struct{}{}
+	s.evictedMu.Unlock()
+
+	// 不再持有该文档，让其他实例可以接管
+	if s.ownership != nil {
+		if err := s.ownership.Release(ctx, d.docID); err != nil {
+			log.Printf("release lease failed doc=%s: %v", d.docID, err)
+		}
+	}
+	return true, nil
+}
+
+// DocumentEvictor：按固定周期执行 EvictIdle
+type DocumentEvictor struct {
+	svc      Service
+	opt      EvictionOptions
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewDocumentEvictor(svc Service, opt EvictionOptions, interval time.Duration) *DocumentEvictor {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	e := &DocumentEvictor{svc: svc, opt: opt, interval: interval, stop: make(chan struct{}), done: make(chan struct{})}
+	go e.loop()
+	return e
+}
+
+func (e *DocumentEvictor) Stop() {
+	close(e.stop)
+	<-e.done
+}
+
+func (e *DocumentEvictor) loop() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+			n, err := e.svc.EvictIdle(ctx, e.opt)
+			cancel()
+			if err != nil {
+				log.Printf("evict documents error (evicted=%d): %v", n, err)
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
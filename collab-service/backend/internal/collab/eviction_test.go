@@ -0,0 +1,79 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+func TestInMemoryService_EvictIdleFlushesAndReloads(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	svc := NewInMemoryService(store, nil, nil, nil)
+	ctx := context.Background()
+
+	for i, docID := range []string{"a", "b", "c"} {
+		ops := delta.Delta{{Kind: delta.KindInsert, Text: docID}}
+		if _, err := svc.Submit(ctx, docID, 1, 0, "c1", uint64(i+1), ops); err != nil {
+			t.Fatalf("Submit(%s) error = %v", docID, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 只保留 1 个文档：最久未访问的 a、b 被淘汰
+	n, err := svc.EvictIdle(ctx, EvictionOptions{MaxDocs: 1})
+	if err != nil {
+		t.Fatalf("EvictIdle() error = %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("EvictIdle() evicted %d docs, want 2", n)
+	}
+	stats := svc.CacheStats()
+	if stats.ResidentDocs != 1 || stats.Evictions != 2 {
+		t.Fatalf("CacheStats() = %+v, want 1 resident, 2 evictions", stats)
+	}
+
+	// 重新访问时从快照加载，内容和版本都不丢
+	content, rev, err := svc.LoadDocumentContent(ctx, "a")
+	if err != nil || content != "a" || rev != 1 {
+		t.Fatalf("LoadDocumentContent(a) = %q, %d, %v; want %q, 1, nil", content, rev, err, "a")
+	}
+	if got := svc.CacheStats().Reloads; got != 1 {
+		t.Fatalf("Reloads = %d, want 1", got)
+	}
+
+	// 空闲超时：所有文档都被淘汰
+	time.Sleep(2 * time.Millisecond)
+	if n, err := svc.EvictIdle(ctx, EvictionOptions{IdleTimeout: time.Millisecond}); err != nil || n != 2 {
+		t.Fatalf("EvictIdle(idle) = %d, %v; want 2, nil", n, err)
+	}
+}
+
+func TestInMemoryService_EvictionKeepsFormattingAndDedup(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	opLog := &fakeOpLog{}
+	svc := NewInMemoryService(store, opLog, nil, nil)
+	ctx := context.Background()
+
+	ops := delta.Delta{{Kind: delta.KindInsert, Text: "Hi", Attrs: map[string]any{"bold": true}}}
+	applied, err := svc.Submit(ctx, "doc", 1, 0, "c1", 1, ops)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if n, err := svc.EvictIdle(ctx, EvictionOptions{IdleTimeout: time.Nanosecond}); err != nil || n != 1 {
+		t.Fatalf("EvictIdle() = %d, %v; want 1 evicted", n, err)
+	}
+
+	// 重新加载后样式还在，快照前已应用操作的重试仍返回原结果
+	doc, _, err := svc.LoadDocumentDelta(ctx, "doc")
+	if err != nil || len(doc) != 1 || doc[0].Attrs["bold"] != true {
+		t.Fatalf("LoadDocumentDelta() = %+v, %v; want bold %q", doc, err, "Hi")
+	}
+	again, err := svc.Submit(ctx, "doc", 1, 0, "c1", 1, ops)
+	var dup *DuplicateOpError
+	if !errors.As(err, &dup) || again.OperationId != applied.OperationId {
+		t.Fatalf("Submit(retry) = %+v, %v; want DuplicateOpError with original op", again, err)
+	}
+}
@@ -3,7 +3,11 @@ package collab
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/IBM/sarama"
@@ -14,11 +18,13 @@ import (
 // - 不阻塞主提交流程（Submit 只负责入队）
 // - Kafka 短暂阻塞时靠队列吸收，后台慢慢补发
 // - 队列满时允许降级（丢弃），避免内存无限增长
+// - 配置了溢出目录时，队列满、重试耗尽、停机时未发完的事件写入磁盘，Kafka 恢复后按顺序补发
 type KafkaDispatcher struct {
 	producer sarama.SyncProducer
 	topic    string
+	encoding EventEncoding
 
-	queue chan DocOpEvent
+	queue chan DocEvent
 
 	// sem 限制并发的 SendMessage 数量。
 	kafkatSem *SemaphoreControl
@@ -27,26 +33,98 @@ type KafkaDispatcher struct {
 	maxRetry    int
 	baseBackoff time.Duration
 	maxBackoff  time.Duration
+
+	// 停止相关：closed 之后 Enqueue 直接拒绝；abort 关闭后 worker 不再发送，剩余事件计为未发送
+	closeMu sync.RWMutex
+	closed  bool
+	abort   chan struct{}
+	wg      sync.WaitGroup
+
+	sent   atomic.Uint64
+	failed atomic.Uint64
+	unsent atomic.Uint64
+
+	// 死信：毒消息或重试耗尽（且没有溢出队列）的事件发往死信 topic，失败时写本地文件
+	dlqTopic          string
+	dlqFile           *deadLetterFile
+	deadLettered      atomic.Uint64
+	deadLetteredLocal atomic.Uint64
+
+	// 磁盘溢出队列，nil 表示不启用。spillMu 串行化"把内存队列转到磁盘再追加"，保证写盘顺序与入队顺序一致
+	spill          *SpillQueue
+	spillMu        sync.Mutex
+	replayInterval time.Duration
+	replayDone     chan struct{}
+}
+
+// 派发统计：Failed 为最终丢弃的事件（溢出队列、死信都写入失败），Unsent 为停止时超过期限仍未发送的事件
+// （启用溢出队列时写入磁盘，只有写盘也失败才计入）
+type DispatcherStats struct {
+	Sent   uint64      `json:"sent"`
+	Failed uint64      `json:"failed"`
+	Unsent uint64      `json:"unsent"`
+	Queued int         `json:"queued"` // 内存队列中待发送的事件
+	Spill  *SpillStats `json:"spill,omitempty"`
+	// 进入死信 topic / 本地 DLQ 文件的事件数
+	DeadLettered      uint64 `json:"deadLettered"`
+	DeadLetteredLocal uint64 `json:"deadLetteredLocal"`
 }
 
+var ErrDispatcherClosed = errors.New("DISPATCHER_CLOSED")
+
 type KafkaDispatcherOptions struct {
 	QueueSize   int
-	Workers     int
+	Workers     int // 多个 worker 时同时在途的事件之间不保证顺序，需要严格按入队顺序发送时设为 1
 	MaxRetry    int
 	BaseBackoff time.Duration
 	MaxBackoff  time.Duration
+
+	// 溢出目录为空时不启用磁盘溢出
+	SpillDir          string
+	SpillMaxBytes     int64         // 所有段的总大小上限，<= 0 表示不限制
+	SpillSegmentBytes int64         // 单个段文件大小，默认 8MB
+	ReplayInterval    time.Duration // 检查并回放积压的周期，默认 1s
+
+	// 死信 topic 为空或发送失败时写入 DeadLetterFile；都为空时死信只记日志
+	DeadLetterTopic string
+	DeadLetterFile  string
+
+	// 消息体编码，默认 JSON
+	Encoding EventEncoding
 }
 
 func NewKafkaDispatcher(producer sarama.SyncProducer, topic string, kafkatSem *SemaphoreControl, opt KafkaDispatcherOptions) *KafkaDispatcher {
 	d := &KafkaDispatcher{
 		producer:    producer,
 		topic:       topic,
-		queue:       make(chan DocOpEvent, opt.QueueSize),
+		encoding:    opt.Encoding,
+		queue:       make(chan DocEvent, opt.QueueSize),
 		kafkatSem:   kafkatSem,
 		workers:     opt.Workers,
 		maxRetry:    opt.MaxRetry,
 		baseBackoff: opt.BaseBackoff,
 		maxBackoff:  opt.MaxBackoff,
+		abort:       make(chan struct{}),
+		dlqTopic:    opt.DeadLetterTopic,
+	}
+	if d.encoding == "" {
+		d.encoding = EncodingJSON
+	}
+	if opt.DeadLetterFile != "" {
+		d.dlqFile = &deadLetterFile{path: opt.DeadLetterFile}
+	}
+	if opt.SpillDir != "" {
+		spill, err := OpenSpillQueue(opt.SpillDir, opt.SpillMaxBytes, opt.SpillSegmentBytes)
+		if err != nil {
+			// 溢出队列不可用时退化为纯内存队列
+			log.Printf("open spill queue %s failed, spilling disabled: %v", opt.SpillDir, err)
+		} else {
+			d.spill = spill
+			d.replayInterval = opt.ReplayInterval
+			if d.replayInterval <= 0 {
+				d.replayInterval = time.Second
+			}
+		}
 	}
 
 	d.Start()
@@ -56,47 +134,196 @@ func NewKafkaDispatcher(producer sarama.SyncProducer, topic string, kafkatSem *S
 // Enqueue：把事件放入本地队列。
 // - 队列满时，等待直到 ctx 超时
 // - ctx 超时返回错误 （kafka不要求强一致性，不是每个事件都必须送达）
-func (d *KafkaDispatcher) Enqueue(ctx context.Context, evt DocOpEvent) error {
+func (d *KafkaDispatcher) Enqueue(ctx context.Context, evt DocEvent) error {
+	// 读锁保证 Stop 关闭队列时没有正在写入的 Enqueue
+	d.closeMu.RLock()
+	defer d.closeMu.RUnlock()
+	if d.closed {
+		return ErrDispatcherClosed
+	}
+	// 磁盘上还有积压时追加到磁盘，不让新事件越过旧事件
+	if d.spill != nil && d.spill.HasBacklog() {
+		return d.spillWithQueued(evt)
+	}
 	select {
 	case d.queue <- evt:
 		return nil
 	case <-ctx.Done():
+		if d.spill != nil {
+			return d.spillWithQueued(evt)
+		}
 		return ctx.Err()
 	}
 }
 
+// 开始写盘时内存队列里可能还有更早的事件：先把它们按顺序转到磁盘，再追加 evt，
+// 否则 worker 之后把它们排到 evt 后面，回放顺序就乱了。调用方持有 closeMu 读锁，队列不会被关闭
+func (d *KafkaDispatcher) spillWithQueued(evt DocEvent) error {
+	d.spillMu.Lock()
+	defer d.spillMu.Unlock()
+	for drained := false; !drained; {
+		select {
+		case queued := <-d.queue:
+			if err := d.spill.Append(queued); err != nil {
+				// 磁盘写满：无法保持顺序，进死信而不是悄悄丢弃
+				d.deadLetter(queued, err, 0)
+			}
+		default:
+			drained = true
+		}
+	}
+	return d.spill.Append(evt)
+}
+
 func (d *KafkaDispatcher) Start() {
 	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
 		go d.workerLoop(i)
 	}
+	if d.spill != nil {
+		d.replayDone = make(chan struct{})
+		go d.replayLoop()
+	}
 }
 
-func (d *KafkaDispatcher) workerLoop(workerID int) {
+// Stop 停止接收新事件，并在 ctx 期限内把队列中剩余的事件发完。
+// 超过期限时放弃剩余事件（记录日志并计入 Unsent），返回 ctx 的错误。
+func (d *KafkaDispatcher) Stop(ctx context.Context) (DispatcherStats, error) {
+	d.closeMu.Lock()
+	if d.closed {
+		d.closeMu.Unlock()
+		return d.Stats(), nil
+	}
+	d.closed = true
+	close(d.queue)
+	d.closeMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// 到期：让 worker 停止发送，剩余事件写盘或丢弃。正在进行中的 SendMessage 无法取消，不再等待
+		err = ctx.Err()
+	}
+	close(d.abort)
 	for evt := range d.queue {
-		d.sendWithRetry(workerID, evt)
+		d.dropUnsent(evt)
 	}
+	if d.spill != nil {
+		<-d.replayDone
+		// 超时未退出的 worker 之后再写盘会得到 ErrSpillClosed，按未发送 / 死信处理，不会再打开新段
+		if cerr := d.spill.Close(); cerr != nil {
+			log.Printf("close spill queue error: %v", cerr)
+		}
+	}
+	return d.Stats(), err
 }
 
-func (d *KafkaDispatcher) sendWithRetry(workerID int, evt DocOpEvent) {
-	for attempt := 0; attempt <= d.maxRetry; attempt++ {
-		if d.kafkatSem != nil {
-			// worker 允许一直等待（不会影响主链路）
-			_ = d.kafkatSem.Acquire(context.Background())
+func (d *KafkaDispatcher) Stats() DispatcherStats {
+	stats := DispatcherStats{Sent: d.sent.Load(), Failed: d.failed.Load(), Unsent: d.unsent.Load(), Queued: len(d.queue),
+		DeadLettered: d.deadLettered.Load(), DeadLetteredLocal: d.deadLetteredLocal.Load()}
+	if d.spill != nil {
+		spill := d.spill.Stats()
+		stats.Spill = &spill
+	}
+	return stats
+}
+
+// 停机时来不及发送的事件：优先写盘，下次启动时补发
+func (d *KafkaDispatcher) dropUnsent(evt DocEvent) {
+	if d.spill != nil {
+		if err := d.spill.Append(evt); err == nil {
+			return
 		}
+	}
+	d.unsent.Add(1)
+	log.Printf("dispatcher stopped, drop unsent event doc=%s type=%s id=%s", evt.DocID, evt.EventType, evt.EventID)
+}
 
-		err := d.sendOnce(evt)
+// 周期性把磁盘积压按顺序补发；发送失败说明 Kafka 仍不可用，等下一个周期再试
+func (d *KafkaDispatcher) replayLoop() {
+	defer close(d.replayDone)
+	ticker := time.NewTicker(d.replayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := d.spill.Replay(func(evt DocEvent) error {
+				if d.aborted() {
+					// 停机中：剩余积压留在磁盘上，下次启动继续
+					return ErrDispatcherClosed
+				}
+				err := d.sendWithSem(evt)
+				if err != nil && isPoisonError(err) {
+					// 毒消息不能卡住后面的积压
+					d.deadLetter(evt, err, 1)
+					return nil
+				}
+				return err
+			})
+			if err != nil {
+				log.Printf("replay spilled events paused: %v", err)
+			}
+		case <-d.abort:
+			return
+		}
+	}
+}
 
-		if d.kafkatSem != nil {
-			_ = d.kafkatSem.Release()
+func (d *KafkaDispatcher) aborted() bool {
+	select {
+	case <-d.abort:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *KafkaDispatcher) workerLoop(workerID int) {
+	defer d.wg.Done()
+	for evt := range d.queue {
+		if d.aborted() {
+			d.dropUnsent(evt)
+			continue
 		}
+		if d.spillBehindBacklog(evt) {
+			continue
+		}
+		d.sendWithRetry(workerID, evt)
+	}
+}
 
+func (d *KafkaDispatcher) sendWithRetry(workerID int, evt DocEvent) {
+	for attempt := 0; attempt <= d.maxRetry; attempt++ {
+		err := d.sendWithSem(evt)
 		if err == nil {
 			return
 		}
+		if isPoisonError(err) {
+			// 重试也不会成功
+			d.deadLetter(evt, err, attempt+1)
+			return
+		}
+		// 其他 worker 已经把更早的事件写盘：不再单独重试，排到积压后面
+		if d.spillBehindBacklog(evt) {
+			return
+		}
 
 		if attempt == d.maxRetry {
-			log.Printf("kafka send failed, drop event doc=%s op=%s rev=%d worker=%d err=%v",
-				evt.DocID, evt.OperationID, evt.Revision, workerID, err)
+			// 重试耗尽（Kafka 不可用）：写盘等待恢复后补发，没有溢出队列（或写满）时进死信
+			if d.spill != nil {
+				if spillErr := d.spill.Append(evt); spillErr == nil {
+					return
+				}
+			}
+			log.Printf("kafka send failed after %d attempts doc=%s type=%s id=%s worker=%d err=%v",
+				attempt+1, evt.DocID, evt.EventType, evt.EventID, workerID, err)
+			d.deadLetter(evt, err, attempt+1)
 			return
 		}
 
@@ -105,23 +332,81 @@ func (d *KafkaDispatcher) sendWithRetry(workerID int, evt DocOpEvent) {
 		if backoff > d.maxBackoff {
 			backoff = d.maxBackoff
 		}
-		time.Sleep(backoff)
+		select {
+		case <-time.After(backoff):
+		case <-d.abort:
+			d.dropUnsent(evt)
+			return
+		}
 	}
 }
 
-func (d *KafkaDispatcher) sendOnce(evt DocOpEvent) error {
+// 溢出队列里有积压时，把事件追加到积压之后由 replayLoop 按顺序补发，不越过已经写盘的事件。
+// 写盘失败（写满 / 已关闭）时返回 false，由调用方照常发送
+func (d *KafkaDispatcher) spillBehindBacklog(evt DocEvent) bool {
+	if d.spill == nil {
+		return false
+	}
+	d.spillMu.Lock()
+	defer d.spillMu.Unlock()
+	return d.spill.HasBacklog() && d.spill.Append(evt) == nil
+}
+
+// 发送一次，受并发信号量限制
+func (d *KafkaDispatcher) sendWithSem(evt DocEvent) error {
+	if d.kafkatSem != nil {
+		// worker 允许一直等待（不会影响主链路）
+		_ = d.kafkatSem.Acquire(context.Background())
+		defer d.kafkatSem.Release()
+	}
+	err := d.sendOnce(evt)
+	if err == nil {
+		d.sent.Add(1)
+	}
+	return err
+}
+
+func (d *KafkaDispatcher) sendOnce(evt DocEvent) error {
 	if d.producer == nil || d.topic == "" {
 		return nil
 	}
-	b, err := json.Marshal(evt)
+	msg, err := eventMessage(d.topic, evt, d.encoding)
 	if err != nil {
 		return err
 	}
-	msg := &sarama.ProducerMessage{
-		Topic: d.topic,
-		Key:   sarama.StringEncoder(evt.DocID),
-		Value: sarama.ByteEncoder(b),
-	}
 	_, _, err = d.producer.SendMessage(msg)
 	return err
 }
+
+// 把投递失败的事件写入死信 topic，失败时退到本地 DLQ 文件；都不可用时丢弃并计入 Failed
+func (d *KafkaDispatcher) deadLetter(evt DocEvent, reason error, attempts int) {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		// 事件本身无法编码，只保留可读的描述
+		payload, _ = json.Marshal(fmt.Sprintf("%+v", evt))
+	}
+	dl := DeadLetter{Topic: d.topic, Key: evt.DocID, Payload: payload, Encoding: d.encoding,
+		Reason: reason.Error(), Attempts: attempts, FailedAt: time.Now()}
+
+	if d.producer != nil && d.dlqTopic != "" {
+		msg, err := deadLetterMessage(d.dlqTopic, dl)
+		if err == nil {
+			_, _, err = d.producer.SendMessage(msg)
+		}
+		if err == nil {
+			d.deadLettered.Add(1)
+			return
+		}
+		log.Printf("send dead letter failed doc=%s id=%s: %v", evt.DocID, evt.EventID, err)
+	}
+	if d.dlqFile != nil {
+		err := d.dlqFile.append(dl)
+		if err == nil {
+			d.deadLetteredLocal.Add(1)
+			return
+		}
+		log.Printf("write local dead letter failed doc=%s id=%s: %v", evt.DocID, evt.EventID, err)
+	}
+	d.failed.Add(1)
+	log.Printf("kafka event dropped doc=%s type=%s id=%s reason=%v", evt.DocID, evt.EventType, evt.EventID, reason)
+}
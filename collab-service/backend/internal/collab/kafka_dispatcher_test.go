@@ -0,0 +1,248 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// 测试用 OP_APPLIED 事件，只填版本号
+func opEvent(docID string, rev uint64) DocEvent {
+	evt, _ := NewDocEvent(strconv.FormatUint(rev, 10), EventOpApplied, docID, time.Now(), OpAppliedPayload{Revision: rev})
+	return evt
+}
+
+func eventRevision(evt DocEvent) uint64 {
+	var p OpAppliedPayload
+	_ = json.Unmarshal(evt.Payload, &p)
+	return p.Revision
+}
+
+// 只实现 SendMessage 的假 producer，release 关闭前一直阻塞
+type blockingProducer struct {
+	sarama.SyncProducer
+	release chan struct{}
+}
+
+func (p *blockingProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	<-p.release
+	return 0, 0, nil
+}
+
+func TestKafkaDispatcher_StopDrainsQueue(t *testing.T) {
+	producer := &blockingProducer{release: make(chan struct{})}
+	close(producer.release)
+	d := NewKafkaDispatcher(producer, "doc-ops", nil, KafkaDispatcherOptions{QueueSize: 8, Workers: 2})
+	for i := 1; i <= 5; i++ {
+		if err := d.Enqueue(context.Background(), opEvent("doc", uint64(i))); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	stats, err := d.Stop(context.Background())
+	if err != nil || stats.Sent != 5 || stats.Unsent != 0 {
+		t.Fatalf("Stop() = %+v, %v; want 5 sent, nil", stats, err)
+	}
+	if err := d.Enqueue(context.Background(), opEvent("doc", 0)); !errors.Is(err, ErrDispatcherClosed) {
+		t.Fatalf("Enqueue() after Stop error = %v, want ErrDispatcherClosed", err)
+	}
+}
+
+func TestKafkaDispatcher_StopDropsAfterDeadline(t *testing.T) {
+	producer := &blockingProducer{release: make(chan struct{})}
+	defer close(producer.release)
+	d := NewKafkaDispatcher(producer, "doc-ops", nil, KafkaDispatcherOptions{QueueSize: 8, Workers: 1})
+	for i := 1; i <= 3; i++ {
+		if err := d.Enqueue(context.Background(), opEvent("doc", uint64(i))); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	// 唯一的 worker 卡在第一条上，其余两条到期后被丢弃
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	stats, err := d.Stop(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || stats.Unsent != 2 {
+		t.Fatalf("Stop() = %+v, %v; want 2 unsent, DeadlineExceeded", stats, err)
+	}
+}
+
+// 可切换成功/失败的假 producer，记录发送成功的版本
+type flakyProducer struct {
+	sarama.SyncProducer
+	mu   sync.Mutex
+	down bool
+	// 只让该版本的事件发送失败（0 表示不生效）
+	failRev uint64
+	sent    []uint64
+}
+
+func (p *flakyProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b, _ := msg.Value.Encode()
+	var evt DocEvent
+	_ = json.Unmarshal(b, &evt)
+	if p.down || (p.failRev != 0 && eventRevision(evt) == p.failRev) {
+		return 0, 0, errors.New("kafka down")
+	}
+	p.sent = append(p.sent, eventRevision(evt))
+	return 0, 0, nil
+}
+
+func (p *flakyProducer) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+}
+
+func (p *flakyProducer) setFailRev(rev uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failRev = rev
+}
+
+func (p *flakyProducer) sentRevisions() []uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]uint64(nil), p.sent...)
+}
+
+func TestKafkaDispatcher_SpillsWhileKafkaDownAndReplays(t *testing.T) {
+	producer := &flakyProducer{down: true}
+	d := NewKafkaDispatcher(producer, "doc-ops", nil, KafkaDispatcherOptions{
+		QueueSize: 8, Workers: 1, SpillDir: t.TempDir(), ReplayInterval: 10 * time.Millisecond,
+	})
+	defer d.Stop(context.Background())
+
+	if err := d.Enqueue(context.Background(), opEvent("doc", 1)); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	// 重试耗尽后写盘；之后的事件因为有积压直接写盘，保持顺序
+	waitFor(t, func() bool { return d.Stats().Spill.Spilled == 1 })
+	for i := 2; i <= 3; i++ {
+		if err := d.Enqueue(context.Background(), opEvent("doc", uint64(i))); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	producer.setDown(false)
+	waitFor(t, func() bool { return len(producer.sentRevisions()) == 3 })
+	if got := producer.sentRevisions(); got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Fatalf("sent %v, want [1 2 3]", got)
+	}
+	if stats := d.Stats(); stats.Failed != 0 || stats.Spill.Segments != 0 {
+		t.Fatalf("Stats() = %+v, want nothing failed and spill drained", stats)
+	}
+}
+
+func TestKafkaDispatcher_QueuedEventsDoNotOvertakeSpilled(t *testing.T) {
+	producer := &flakyProducer{failRev: 1}
+	d := NewKafkaDispatcher(producer, "doc-ops", nil, KafkaDispatcherOptions{
+		QueueSize: 8, Workers: 1, MaxRetry: 1, BaseBackoff: 50 * time.Millisecond, MaxBackoff: 50 * time.Millisecond,
+		SpillDir: t.TempDir(), ReplayInterval: 10 * time.Millisecond,
+	})
+	defer d.Stop(context.Background())
+
+	// 1 在退避重试期间 2、3 已经进入内存队列；1 写盘之后它们也要排到磁盘积压后面
+	for i := 1; i <= 3; i++ {
+		if err := d.Enqueue(context.Background(), opEvent("doc", uint64(i))); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	waitFor(t, func() bool { return d.Stats().Spill.Spilled == 3 })
+	if got := producer.sentRevisions(); len(got) != 0 {
+		t.Fatalf("sent %v before the spilled event, want nothing", got)
+	}
+
+	producer.setFailRev(0)
+	waitFor(t, func() bool { return len(producer.sentRevisions()) == 3 })
+	if got := producer.sentRevisions(); got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Fatalf("sent %v, want [1 2 3]", got)
+	}
+}
+
+// release 关闭前阻塞的假 producer，记录发送的版本
+type gatedProducer struct {
+	sarama.SyncProducer
+	release chan struct{}
+	mu      sync.Mutex
+	sent    []uint64
+}
+
+func (p *gatedProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	<-p.release
+	b, _ := msg.Value.Encode()
+	var evt DocEvent
+	_ = json.Unmarshal(b, &evt)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, eventRevision(evt))
+	return 0, 0, nil
+}
+
+func (p *gatedProducer) sentRevisions() []uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]uint64(nil), p.sent...)
+}
+
+func TestKafkaDispatcher_FullQueueSpillKeepsEnqueueOrder(t *testing.T) {
+	producer := &gatedProducer{release: make(chan struct{})}
+	d := NewKafkaDispatcher(producer, "doc-ops", nil, KafkaDispatcherOptions{
+		QueueSize: 2, Workers: 1, SpillDir: t.TempDir(), ReplayInterval: 10 * time.Millisecond,
+	})
+	var release sync.Once
+	releaseProducer := func() { release.Do(func() { close(producer.release) }) }
+	defer d.Stop(context.Background())
+	defer releaseProducer()
+
+	// worker 卡在 1 上，2、3 占满队列
+	if err := d.Enqueue(context.Background(), opEvent("doc", 1)); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	waitFor(t, func() bool { return d.Stats().Queued == 0 })
+	for i := 2; i <= 3; i++ {
+		if err := d.Enqueue(context.Background(), opEvent("doc", uint64(i))); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	// 4 等待超时开始写盘，队列里更早的 2、3 要先转到磁盘；5 追加在积压之后
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Enqueue(ctx, opEvent("doc", 4)); err != nil {
+		t.Fatalf("Enqueue() on full queue error = %v", err)
+	}
+	if err := d.Enqueue(context.Background(), opEvent("doc", 5)); err != nil {
+		t.Fatalf("Enqueue() behind backlog error = %v", err)
+	}
+	if stats := d.Stats(); stats.Queued != 0 || stats.Spill.Spilled != 4 {
+		t.Fatalf("stats = %+v, want empty queue and 4 spilled", stats)
+	}
+
+	releaseProducer()
+	waitFor(t, func() bool { return len(producer.sentRevisions()) == 5 })
+	for i, rev := range producer.sentRevisions() {
+		if rev != uint64(i+1) {
+			t.Fatalf("sent %v, want [1 2 3 4 5]", producer.sentRevisions())
+		}
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,30 @@
+package collab
+
+import "collabServer/backend/internal/idgen"
+
+// InMemoryService 的可选配置
+type ServiceOption func(*InMemoryService)
+
+// WithIDGenerator 指定操作 ID / 文档 ID 生成器（默认节点 ID 为 0）
+func WithIDGenerator(g *idgen.Generator) ServiceOption {
+	return func(s *InMemoryService) {
+		s.ids = g
+	}
+}
+
+// WithOpListener 注册已应用操作的监听器：每个文档的操作按 revision 严格递增的顺序、
+// 在该文档的 emitter goroutine 中调用（不占用 sequencer），监听器不应长时间阻塞
+func WithOpListener(fn func(docID string, op AppliedOp)) ServiceOption {
+	return func(s *InMemoryService) {
+		s.opListeners = append(s.opListeners, fn)
+	}
+}
+
+// WithMaxRebaseOps 设置提交时 baseRevision 最多落后的版本数（默认 1024），超过时 Submit 返回 ErrBaseTooOld
+func WithMaxRebaseOps(n int) ServiceOption {
+	return func(s *InMemoryService) {
+		if n > 0 {
+			s.maxRebaseOps = n
+		}
+	}
+}
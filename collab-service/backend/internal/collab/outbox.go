@@ -0,0 +1,164 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+// outbox 模式下，事件与操作日志 / 快照在同一个数据库事务里写入，再由 OutboxRelay 异步发布：
+// 事件与数据要么都落库要么都不落库，不会出现「发了事件但操作丢了」或反过来的情况。
+
+// 能在写操作日志的同一事务里写入事件的存储
+type TransactionalOpLog interface {
+	AppendOpWithEvent(ctx context.Context, docID string, op AppliedOp, evt DocEvent, fence uint64) error
+}
+
+// 能在写快照的同一事务里写入事件的存储
+type TransactionalSnapshotStore interface {
+	SaveDocumentSnapshotWithEvent(ctx context.Context, docID string, rev uint64, doc delta.Delta, evt DocEvent, fence uint64) error
+}
+
+// outbox 表中的一条待发布事件
+type OutboxRecord struct {
+	ID        uint64
+	DocID     string
+	EventType string
+	EventID   string
+	Payload   []byte // DocEvent 的 JSON
+}
+
+// OutboxRelay 读取的 outbox 存储
+type OutboxStore interface {
+	// 有未发布事件的文档
+	PendingDocuments(ctx context.Context, limit int) ([]string, error)
+	// 认领 docID 最早的至多 limit 条未发布事件，按顺序逐条交给 publish：成功的立即标记为已发送并续期其余事件的认领，
+	// publish 返回错误时停止并释放剩余认领；认领未过期期间其他调用方跳过该文档，发布时不持有数据库锁
+	ProcessPending(ctx context.Context, docID string, limit int, publish func(OutboxRecord) error) error
+}
+
+// WithOutbox 启用事务性 outbox：操作与快照事件随操作日志 / 快照同一事务落库。
+// 其余事件（文档创建、成员加入 / 离开等）经 EventPublisher 发出，outbox 模式下应传入同样写 outbox 表的 publisher。
+//
+// 顺序：OP_APPLIED 在 sequencer 内落库，outbox id 与 revision 同序；SNAPSHOT_SAVED 在 sequencer 之外写快照时落库，
+// 可能排在 revision 更大的 OP_APPLIED 之后。消费方应按 payload.revision 判断快照位置，不依赖它与 OP_APPLIED 的相对顺序。
+func WithOutbox(ops TransactionalOpLog, snapshots TransactionalSnapshotStore) ServiceOption {
+	return func(s *InMemoryService) {
+		s.outboxOps = ops
+		s.outboxSnapshots = snapshots
+	}
+}
+
+type OutboxRelayOptions struct {
+	PollInterval time.Duration // 默认 200ms
+	BatchSize    int           // 每个文档每次最多发布的事件数，默认 100
+	MaxDocs      int           // 每轮最多处理的文档数，默认 100
+	Encoding     EventEncoding // 消息体编码，默认 JSON
+}
+
+// OutboxRelay：轮询 outbox 表，按文档、按 id 顺序把事件发布到 Kafka 并标记为已发送。
+// 某条事件发送失败时该文档后续事件留到下一轮，其他文档不受影响。
+type OutboxRelay struct {
+	store    OutboxStore
+	producer sarama.SyncProducer
+	topic    string
+	opt      OutboxRelayOptions
+
+	published atomic.Uint64
+	failures  atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// 发布统计
+type OutboxRelayStats struct {
+	Published uint64 `json:"published"`
+	Failures  uint64 `json:"failures"`
+}
+
+func NewOutboxRelay(store OutboxStore, producer sarama.SyncProducer, topic string, opt OutboxRelayOptions) *OutboxRelay {
+	if opt.PollInterval <= 0 {
+		opt.PollInterval = 200 * time.Millisecond
+	}
+	if opt.BatchSize <= 0 {
+		opt.BatchSize = 100
+	}
+	if opt.MaxDocs <= 0 {
+		opt.MaxDocs = 100
+	}
+	if opt.Encoding == "" {
+		opt.Encoding = EncodingJSON
+	}
+	r := &OutboxRelay{store: store, producer: producer, topic: topic, opt: opt,
+		stop: make(chan struct{}), done: make(chan struct{})}
+	go r.loop()
+	return r
+}
+
+// Stop 等当前一轮发布结束后退出，未发布的事件留在表中，下次启动继续
+func (r *OutboxRelay) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *OutboxRelay) Stats() OutboxRelayStats {
+	return OutboxRelayStats{Published: r.published.Load(), Failures: r.failures.Load()}
+}
+
+func (r *OutboxRelay) loop() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opt.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.RunOnce(context.Background())
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// RunOnce 处理一轮：对每个有积压的文档发布一批事件
+func (r *OutboxRelay) RunOnce(ctx context.Context) {
+	docIDs, err := r.store.PendingDocuments(ctx, r.opt.MaxDocs)
+	if err != nil {
+		log.Printf("outbox relay: list pending documents error: %v", err)
+		return
+	}
+	for _, docID := range docIDs {
+		if err := r.store.ProcessPending(ctx, docID, r.opt.BatchSize, r.publish); err != nil {
+			log.Printf("outbox relay: process doc=%s error: %v", docID, err)
+		}
+	}
+}
+
+// 发布一条事件，失败时返回错误，由 ProcessPending 停在这里
+func (r *OutboxRelay) publish(rec OutboxRecord) error {
+	var evt DocEvent
+	err := json.Unmarshal(rec.Payload, &evt)
+	var msg *sarama.ProducerMessage
+	if err == nil {
+		msg, err = eventMessage(r.topic, evt, r.opt.Encoding)
+	}
+	if err != nil {
+		// 无法编码的事件会一直卡住该文档后续的事件，跳过（按已处理标记）并记录
+		r.failures.Add(1)
+		log.Printf("outbox relay: skip undecodable event doc=%s outbox=%d: %v", rec.DocID, rec.ID, err)
+		return nil
+	}
+	if _, _, err := r.producer.SendMessage(msg); err != nil {
+		r.failures.Add(1)
+		log.Printf("outbox relay: publish doc=%s outbox=%d error: %v", rec.DocID, rec.ID, err)
+		return err
+	}
+	r.published.Add(1)
+	return nil
+}
@@ -0,0 +1,112 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+// 内存版 outbox：操作日志与事件「同一事务」写入
+type fakeOutbox struct {
+	mu      sync.Mutex
+	ops     []AppliedOp
+	records []OutboxRecord
+	sent    map[uint64]bool
+}
+
+func (f *fakeOutbox) AppendOpWithEvent(ctx context.Context, docID string, op AppliedOp, evt DocEvent, fence uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payload, _ := json.Marshal(evt)
+	f.ops = append(f.ops, op)
+	f.records = append(f.records, OutboxRecord{
+		ID: uint64(len(f.records) + 1), DocID: docID, EventType: evt.EventType, EventID: evt.EventID, Payload: payload,
+	})
+	return nil
+}
+
+func (f *fakeOutbox) Publish(ctx context.Context, evt DocEvent) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	payload, _ := json.Marshal(evt)
+	f.records = append(f.records, OutboxRecord{
+		ID: uint64(len(f.records) + 1), DocID: evt.DocID, EventType: evt.EventType, EventID: evt.EventID, Payload: payload,
+	})
+	return nil
+}
+
+func (f *fakeOutbox) PendingDocuments(ctx context.Context, limit int) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	seen := map[string]bool{}
+	var out []string
+	for _, r := range f.records {
+		if !f.sent[r.ID] && !seen[r.DocID] {
+			seen[r.DocID] = true
+			out = append(out, r.DocID)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeOutbox) ProcessPending(ctx context.Context, docID string, limit int, publish func(OutboxRecord) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var pending []OutboxRecord
+	for _, r := range f.records {
+		if r.DocID == docID && !f.sent[r.ID] && len(pending) < limit {
+			pending = append(pending, r)
+		}
+	}
+	if f.sent == nil {
+		f.sent = make(map[uint64]bool)
+	}
+	for _, r := range pending {
+		if err := publish(r); err != nil {
+			return nil
+		}
+		f.sent[r.ID] = true
+	}
+	return nil
+}
+
+func TestOutboxRelay_PublishesInOrderAfterKafkaRecovers(t *testing.T) {
+	outbox := &fakeOutbox{}
+	svc := NewInMemoryService(nil, nil, nil, outbox, WithOutbox(outbox, nil))
+	ctx := context.Background()
+	for i := uint64(0); i < 3; i++ {
+		if _, err := svc.Submit(ctx, "doc", 1, i, "c1", i+1, delta.Delta{{Kind: delta.KindInsert, Text: "x"}}); err != nil {
+			t.Fatalf("Submit(%d) error = %v", i, err)
+		}
+	}
+	if len(outbox.ops) != 3 || len(outbox.records) != 3 {
+		t.Fatalf("outbox has %d ops, %d events; want 3, 3", len(outbox.ops), len(outbox.records))
+	}
+	// 操作事件以操作 ID 作为事件 ID
+	if outbox.records[0].EventType != EventOpApplied || outbox.records[0].EventID != outbox.ops[0].OperationId {
+		t.Fatalf("first outbox record = %+v, want OP_APPLIED with id %s", outbox.records[0], outbox.ops[0].OperationId)
+	}
+
+	producer := &flakyProducer{down: true}
+	relay := NewOutboxRelay(outbox, producer, "doc-ops", OutboxRelayOptions{PollInterval: time.Hour})
+	defer relay.Stop()
+
+	// Kafka 不可用：事件全部留在 outbox
+	relay.RunOnce(ctx)
+	if pending, _ := outbox.PendingDocuments(ctx, 10); len(pending) != 1 {
+		t.Fatalf("pending docs = %v, want [doc]", pending)
+	}
+
+	producer.setDown(false)
+	relay.RunOnce(ctx)
+	if len(producer.sent) != 3 || producer.sent[0] != 1 || producer.sent[2] != 3 {
+		t.Fatalf("published revisions = %v, want [1 2 3]", producer.sent)
+	}
+	if stats := relay.Stats(); stats.Published != 3 || stats.Failures != 1 {
+		t.Fatalf("Stats() = %+v, want 3 published, 1 failure", stats)
+	}
+}
@@ -0,0 +1,84 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Ownership：多实例部署时的文档归属（租约）。
+// 同一时刻只有持有租约的实例可以给文档排序（分配 revision），其他实例需要把客户端重定向过去。
+// 每次（重新）获得租约时 token 单调递增，用来识别「中途丢过租约」的本地状态。
+type Ownership interface {
+	// Ensure 确保本实例持有 docID 的租约。ok 为 false 时 owner 为当前持有者的地址。
+	Ensure(ctx context.Context, docID string) (owner string, token uint64, ok bool, err error)
+	// Release 主动释放租约（淘汰文档、停机时）
+	Release(ctx context.Context, docID string) error
+	// OnLost 注册租约丢失（续约失败/被接管）时的回调
+	OnLost(fn func(docID string))
+}
+
+// 存储拒绝了写入：该文档已被持有更新租约 token 的实例写过，本实例的租约已经失效
+var ErrFenced = errors.New("FENCED")
+
+// 本实例不是文档 owner 时返回，Owner 为应当连接的实例地址
+type NotOwnerError struct {
+	DocID string
+	Owner string
+}
+
+func (e *NotOwnerError) Error() string {
+	return fmt.Sprintf("NOT_OWNER: document %s is owned by %s", e.DocID, e.Owner)
+}
+
+// WithOwnership 启用多实例文档归属
+func WithOwnership(o Ownership) ServiceOption {
+	return func(s *InMemoryService) {
+		s.ownership = o
+		o.OnLost(s.dropDoc)
+	}
+}
+
+// 确认本实例仍是 owner，返回租约 token
+func (s *InMemoryService) ensureOwner(ctx context.Context, docID string) (uint64, error) {
+	owner, token, ok, err := s.ownership.Ensure(ctx, docID)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, &NotOwnerError{DocID: docID, Owner: owner}
+	}
+	return token, nil
+}
+
+// 写入被 fencing 拒绝时丢弃本地状态（与租约丢失相同），下次访问重新确认归属并加载
+func (s *InMemoryService) checkFenced(docID string, ds *docState, err error) error {
+	if errors.Is(err, ErrFenced) {
+		s.discardDoc(docID, ds)
+	}
+	return err
+}
+
+// 租约丢失：本地状态可能已经被新 owner 推进，直接丢弃（不快照），下次访问重新加载
+func (s *InMemoryService) dropDoc(docID string) {
+	s.mu.RLock()
+	ds := s.docs[docID]
+	s.mu.RUnlock()
+	if ds != nil {
+		s.discardDoc(docID, ds)
+	}
+}
+
+// 仅当 docs[docID] 仍是 ds 时把它移除并让它的 sequencer 退出（正在执行的命令会先执行完）
+func (s *InMemoryService) discardDoc(docID string, ds *docState) {
+	s.mu.Lock()
+	if s.docs[docID] != ds {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.docs, docID)
+	s.mu.Unlock()
+	ds.retire()
+	log.Printf("document lease lost or fenced, dropped local state doc=%s", docID)
+}
@@ -0,0 +1,144 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+// 多个实例共享的内存租约表
+type fakeLeases struct {
+	mu     sync.Mutex
+	owner  map[string]string
+	token  map[string]uint64
+	onLost map[string]func(docID string)
+}
+
+func newFakeLeases() *fakeLeases {
+	return &fakeLeases{owner: map[string]string{}, token: map[string]uint64{}, onLost: map[string]func(string){}}
+}
+
+// 把 docID 的租约转给 instance，原 owner 收到 OnLost 回调
+func (f *fakeLeases) takeover(docID, instance string) {
+	f.mu.Lock()
+	prev := f.owner[docID]
+	f.owner[docID] = instance
+	f.token[docID]++
+	lost := f.onLost[prev]
+	f.mu.Unlock()
+	if lost != nil {
+		lost(docID)
+	}
+}
+
+type fakeOwnership struct {
+	leases   *fakeLeases
+	instance string
+}
+
+func (o *fakeOwnership) Ensure(ctx context.Context, docID string) (string, uint64, bool, error) {
+	f := o.leases
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if owner, ok := f.owner[docID]; ok && owner != o.instance {
+		return owner, 0, false, nil
+	}
+	if _, ok := f.owner[docID]; !ok {
+		f.owner[docID] = o.instance
+		f.token[docID]++
+	}
+	return o.instance, f.token[docID], true, nil
+}
+
+func (o *fakeOwnership) Release(ctx context.Context, docID string) error {
+	f := o.leases
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.owner[docID] == o.instance {
+		delete(f.owner, docID)
+	}
+	return nil
+}
+
+func (o *fakeOwnership) OnLost(fn func(docID string)) {
+	o.leases.mu.Lock()
+	defer o.leases.mu.Unlock()
+	o.leases.onLost[o.instance] = fn
+}
+
+func TestInMemoryService_OwnershipTakeoverReloadsState(t *testing.T) {
+	leases := newFakeLeases()
+	store := &fakeSnapshotStore{}
+	opLog := &fakeOpLog{}
+	a := NewInMemoryService(store, opLog, nil, nil, WithOwnership(&fakeOwnership{leases: leases, instance: "a:3002"}))
+	b := NewInMemoryService(store, opLog, nil, nil, WithOwnership(&fakeOwnership{leases: leases, instance: "b:3002"}))
+	ctx := context.Background()
+
+	if _, err := a.Submit(ctx, "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "hello"}}); err != nil {
+		t.Fatalf("a.Submit() error = %v", err)
+	}
+
+	// 非 owner 拒绝排序并告知 owner 地址
+	_, err := b.Submit(ctx, "doc", 2, 1, "c2", 1, delta.Delta{{Kind: delta.KindRetain, Count: 5}, {Kind: delta.KindInsert, Text: "!"}})
+	var notOwner *NotOwnerError
+	if !errors.As(err, &notOwner) || notOwner.Owner != "a:3002" {
+		t.Fatalf("b.Submit() error = %v, want NotOwnerError{Owner: a:3002}", err)
+	}
+
+	// a 失去租约：b 接管，从快照 + 操作日志恢复出 a 未快照的修改
+	leases.takeover("doc", "b:3002")
+	if _, err := b.Submit(ctx, "doc", 2, 1, "c2", 1, delta.Delta{{Kind: delta.KindRetain, Count: 5}, {Kind: delta.KindInsert, Text: "!"}}); err != nil {
+		t.Fatalf("b.Submit() after takeover error = %v", err)
+	}
+	if _, _, err := a.LoadDocumentContent(ctx, "doc"); !errors.As(err, &notOwner) {
+		t.Fatalf("a.LoadDocumentContent() error = %v, want NotOwnerError", err)
+	}
+
+	// 租约回到 a：丢弃旧状态重新加载，能看到 b 写入的版本
+	leases.takeover("doc", "a:3002")
+	content, rev, err := a.LoadDocumentContent(ctx, "doc")
+	if err != nil || content != "hello!" || rev != 2 {
+		t.Fatalf("a.LoadDocumentContent() = %q, %d, %v; want %q, 2, nil", content, rev, err, "hello!")
+	}
+}
+
+// 始终认为自己持有租约（token 固定）：模拟确认归属之后、写入之前租约已被接管的旧 owner
+type staleOwnership struct{ token uint64 }
+
+func (o staleOwnership) Ensure(ctx context.Context, docID string) (string, uint64, bool, error) {
+	return "a:3002", o.token, true, nil
+}
+func (o staleOwnership) Release(ctx context.Context, docID string) error { return nil }
+func (o staleOwnership) OnLost(fn func(docID string))                    {}
+
+func TestInMemoryService_StaleOwnerWriteIsFenced(t *testing.T) {
+	opLog := &fakeOpLog{}
+	stale := NewInMemoryService(nil, opLog, nil, nil, WithOwnership(staleOwnership{token: 1})).(*InMemoryService)
+	current := NewInMemoryService(nil, opLog, nil, nil, WithOwnership(staleOwnership{token: 2}))
+	ctx := context.Background()
+	ops := delta.Delta{{Kind: delta.KindInsert, Text: "x"}}
+
+	if _, err := stale.Submit(ctx, "doc", 1, 0, "c1", 1, ops); err != nil {
+		t.Fatalf("stale Submit() before takeover error = %v", err)
+	}
+	if _, err := current.Submit(ctx, "doc", 2, 1, "c2", 1, ops); err != nil {
+		t.Fatalf("current Submit() error = %v", err)
+	}
+
+	// 新 owner 写过之后，旧 owner 的写入被存储拒绝，本地状态随之丢弃
+	if _, err := stale.Submit(ctx, "doc", 1, 1, "c1", 2, ops); !errors.Is(err, ErrFenced) {
+		t.Fatalf("stale Submit() error = %v, want ErrFenced", err)
+	}
+	stale.mu.RLock()
+	_, resident := stale.docs["doc"]
+	stale.mu.RUnlock()
+	if resident {
+		t.Fatal("stale owner kept local state after being fenced")
+	}
+	if got := len(opLog.ops["doc"]); got != 2 {
+		t.Fatalf("op log has %d ops, want 2", got)
+	}
+}
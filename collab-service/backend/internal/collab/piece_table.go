@@ -1,6 +1,21 @@
 package collab
 
-import "collabServer/backend/internal/ot/delta"
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+// Apply 校验失败时返回的错误，错误信息会透传给客户端
+var (
+	ErrInvalidOpKind    = errors.New("INVALID_OP_KIND")
+	ErrInvalidOpCount   = errors.New("INVALID_OP_COUNT")
+	ErrEmptyInsert      = errors.New("EMPTY_INSERT")
+	ErrRetainOutOfRange = errors.New("RETAIN_OUT_OF_RANGE")
+	ErrDeleteOutOfRange = errors.New("DELETE_OUT_OF_RANGE")
+)
 
 type bufferKind int
 
@@ -15,6 +30,8 @@ type piece struct {
 	buf    bufferKind
 	offset int // 偏移量
 	length int
+	// 这段文本的样式属性（粗体/颜色等），nil 表示无样式；只整体替换，不原地修改
+	attrs map[string]any
 }
 
 type PieceTable struct {
@@ -55,7 +72,40 @@ func (pt *PieceTable) String() string {
 	return res
 }
 
+// 在修改任何状态之前，先按文档长度校验整个 delta
+func validateDelta(d delta.Delta, length int) error {
+	// pos 是在原文档上的位置（insert 不消耗原文档）
+	pos := 0
+	for i, op := range d {
+		switch op.Kind {
+		case delta.KindRetain, delta.KindDelete:
+			if op.Count <= 0 {
+				return fmt.Errorf("%w: op[%d] %s count=%d", ErrInvalidOpCount, i, op.Kind, op.Count)
+			}
+			if pos+op.Count > length {
+				err := ErrRetainOutOfRange
+				if op.Kind == delta.KindDelete {
+					err = ErrDeleteOutOfRange
+				}
+				return fmt.Errorf("%w: op[%d] %s %d at %d exceeds length %d", err, i, op.Kind, op.Count, pos, length)
+			}
+			pos += op.Count
+		case delta.KindInsert:
+			if op.Text == "" {
+				return fmt.Errorf("%w: op[%d]", ErrEmptyInsert, i)
+			}
+		default:
+			return fmt.Errorf("%w: op[%d] kind=%q", ErrInvalidOpKind, i, op.Kind)
+		}
+	}
+	return nil
+}
+
+// Apply 校验通过后才会修改 piece table；校验失败时 piece table 保持不变
 func (pt *PieceTable) Apply(d delta.Delta) error {
+	if err := validateDelta(d, pt.Len()); err != nil {
+		return err
+	}
 	pos := 0
 	//retain: 沿 piece 列表向前走，对应“移动 pos”；
 	//insert: 在当前 pos 调用 insert 流程；
@@ -63,6 +113,10 @@ func (pt *PieceTable) Apply(d delta.Delta) error {
 	for _, op := range d {
 		switch op.Kind {
 		case delta.KindRetain:
+			// 带属性的 retain：给 [pos, pos+count) 这段已有文本设置/移除样式
+			if len(op.Attrs) > 0 {
+				pt.format(pos, op.Count, op.Attrs)
+			}
 			pos += op.Count
 
 		case delta.KindInsert:
@@ -72,14 +126,15 @@ func (pt *PieceTable) Apply(d delta.Delta) error {
 			length := len(d_rune)
 
 			idx, offset := pt.locate(pos)
-			new_piece := piece{buf: bufAdd, offset: start, length: length}
+			new_piece := piece{buf: bufAdd, offset: start, length: length, attrs: delta.ComposeAttrs(nil, op.Attrs, false)}
 
 			if idx < len(pt.pieces) {
 				cur := pt.pieces[idx]
-				left_piece := piece{buf: cur.buf, offset: pt.pieces[idx].offset, length: offset}
-				right_piece := piece{buf: cur.buf, offset: pt.pieces[idx].offset + offset, length: pt.pieces[idx].length - offset}
+				left_piece := piece{buf: cur.buf, offset: pt.pieces[idx].offset, length: offset, attrs: cur.attrs}
+				right_piece := piece{buf: cur.buf, offset: pt.pieces[idx].offset + offset, length: pt.pieces[idx].length - offset, attrs: cur.attrs}
 
-				newPieces := make([]piece, 0, len(pt.pieces)+1)
+				newPieces := make([]piece, 0, len(pt.pieces)+2)
+				newPieces = append(newPieces, pt.pieces[:idx]...)
 
 				if left_piece.length > 0 {
 					newPieces = append(newPieces, left_piece)
@@ -138,6 +193,7 @@ func (pt *PieceTable) Apply(d delta.Delta) error {
 							buf:    cur.buf,
 							offset: cur.offset,
 							length: leftLen,
+							attrs:  cur.attrs,
 						})
 					}
 					if rightLen > 0 {
@@ -145,6 +201,7 @@ func (pt *PieceTable) Apply(d delta.Delta) error {
 							buf:    cur.buf,
 							offset: cur.offset + offset + take,
 							length: rightLen,
+							attrs:  cur.attrs,
 						})
 					}
 					newPieces = append(newPieces, pt.pieces[idx+1:]...)
@@ -158,6 +215,44 @@ func (pt *PieceTable) Apply(d delta.Delta) error {
 	return nil
 }
 
+// 对 [pos, pos+length) 范围内的文本合并样式属性，值为 nil 的属性会被移除
+func (pt *PieceTable) format(pos, length int, attrs map[string]any) {
+	start := pt.splitAt(pos)
+	end := pt.splitAt(pos + length)
+	for i := start; i < end; i++ {
+		pt.pieces[i].attrs = delta.ComposeAttrs(pt.pieces[i].attrs, attrs, false)
+	}
+}
+
+// 保证 pos 处是 piece 边界（必要时把一个 piece 拆成两段），返回从 pos 开始的 piece 下标
+func (pt *PieceTable) splitAt(pos int) int {
+	idx, offset := pt.locate(pos)
+	if idx >= len(pt.pieces) || offset == 0 {
+		return idx
+	}
+	cur := pt.pieces[idx]
+	right := piece{buf: cur.buf, offset: cur.offset + offset, length: cur.length - offset, attrs: cur.attrs}
+	pt.pieces[idx].length = offset
+	pt.pieces = slices.Insert(pt.pieces, idx+1, right)
+	return idx + 1
+}
+
+// Delta 以「只含 insert 的 delta」形式返回带样式的完整文档，相邻同样式的文本会被合并
+func (pt *PieceTable) Delta() delta.Delta {
+	doc := make(delta.Delta, 0, len(pt.pieces))
+	for _, p := range pt.pieces {
+		if p.length == 0 {
+			continue
+		}
+		src := pt.original
+		if p.buf == bufAdd {
+			src = pt.add
+		}
+		doc = append(doc, delta.Op{Kind: delta.KindInsert, Text: string(src[p.offset : p.offset+p.length]), Attrs: p.attrs})
+	}
+	return delta.Normalize(doc)
+}
+
 // 根据逻辑位置 pos，找到对应的 piece 下标 idx 和在该 piece 内的偏移 offset
 func (pt *PieceTable) locate(pos int) (idx int, offset int) {
 	cur := 0
@@ -1,6 +1,8 @@
 package collab
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 
 	"collabServer/backend/internal/ot/delta"
@@ -34,6 +36,22 @@ func TestPieceTable_InsertMiddle(t *testing.T) {
 	}
 }
 
+// 回归：在非第一个 piece 的中间插入时，之前的 piece 曾被整体丢掉
+func TestPieceTable_InsertInsideLaterPiece(t *testing.T) {
+	pt := NewPieceTable("Hello world")
+	// 第一次插入把文档拆成 "Hello" / "," / " world" 三个 piece
+	if err := pt.Apply(delta.Delta{{Kind: delta.KindRetain, Count: 5}, {Kind: delta.KindInsert, Text: ","}}); err != nil {
+		t.Fatalf("Apply(,) error = %v", err)
+	}
+	// 第二次插入落在第三个 piece " world" 的中间
+	if err := pt.Apply(delta.Delta{{Kind: delta.KindRetain, Count: 9}, {Kind: delta.KindInsert, Text: "-"}}); err != nil {
+		t.Fatalf("Apply(-) error = %v", err)
+	}
+	if want := "Hello, wo-rld"; pt.String() != want {
+		t.Fatalf("String() = %q, want %q", pt.String(), want)
+	}
+}
+
 func TestPieceTable_DeleteMiddle(t *testing.T) {
 	pt := NewPieceTable("Hello collaborative world")
 
@@ -54,3 +72,64 @@ func TestPieceTable_DeleteMiddle(t *testing.T) {
 		t.Fatalf("String() = %q, want %q", got, want)
 	}
 }
+
+func TestPieceTable_ApplyRejectsInvalidDelta(t *testing.T) {
+	cases := []struct {
+		name string
+		d    delta.Delta
+		want error
+	}{
+		{"retain past end", delta.Delta{{Kind: delta.KindRetain, Count: 12}}, ErrRetainOutOfRange},
+		{"delete past end", delta.Delta{{Kind: delta.KindRetain, Count: 6}, {Kind: delta.KindDelete, Count: 6}}, ErrDeleteOutOfRange},
+		{"negative count", delta.Delta{{Kind: delta.KindDelete, Count: -1}}, ErrInvalidOpCount},
+		{"empty insert", delta.Delta{{Kind: delta.KindInsert, Text: ""}}, ErrEmptyInsert},
+		{"unknown kind", delta.Delta{{Kind: "format", Count: 1}}, ErrInvalidOpKind},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pt := NewPieceTable("Hello world")
+			// 前面合法的 op 也不能生效
+			d := append(delta.Delta{{Kind: delta.KindInsert, Text: ">"}}, tc.d...)
+			if err := pt.Apply(d); !errors.Is(err, tc.want) {
+				t.Fatalf("Apply() error = %v, want %v", err, tc.want)
+			}
+			if got := pt.String(); got != "Hello world" {
+				t.Fatalf("String() = %q after rejected Apply, want unchanged", got)
+			}
+		})
+	}
+}
+
+func TestPieceTable_FormatAttributes(t *testing.T) {
+	pt := NewPieceTable("Hello world")
+
+	// 给 "world" 加粗，再插入一段带链接的文本
+	d := delta.Delta{
+		{Kind: delta.KindRetain, Count: 6},
+		{Kind: delta.KindRetain, Count: 5, Attrs: map[string]any{"bold": true}},
+		{Kind: delta.KindInsert, Text: "!", Attrs: map[string]any{"link": "https://example.com"}},
+	}
+	if err := pt.Apply(d); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	// 取消 "wor" 的粗体
+	d = delta.Delta{
+		{Kind: delta.KindRetain, Count: 6},
+		{Kind: delta.KindRetain, Count: 3, Attrs: map[string]any{"bold": nil}},
+	}
+	if err := pt.Apply(d); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	want := delta.Delta{
+		{Kind: delta.KindInsert, Text: "Hello wor"},
+		{Kind: delta.KindInsert, Text: "ld", Attrs: map[string]any{"bold": true}},
+		{Kind: delta.KindInsert, Text: "!", Attrs: map[string]any{"link": "https://example.com"}},
+	}
+	if got := pt.Delta(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Delta() = %+v, want %+v", got, want)
+	}
+	if got := pt.String(); got != "Hello world!" {
+		t.Fatalf("String() = %q, want %q", got, "Hello world!")
+	}
+}
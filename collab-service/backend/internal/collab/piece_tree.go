@@ -0,0 +1,254 @@
+package collab
+
+import (
+	"strings"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+// PieceTree 与 PieceTable 语义相同的 Buffer 实现，但 piece 不再放在切片里，
+// 而是放在一棵按「累计长度」做隐式 key 的 AVL 树上：
+// - 每个节点缓存子树文本总长度 size，按位置定位只需沿树下降，O(log n)
+// - insert/delete/format 都转换成 split（按位置切开）+ join（拼回去），O(log n)
+// - Len() 直接读根节点 size，O(1)
+//
+// original/add 两个只追加的 rune 缓冲区与 PieceTable 一致。
+type PieceTree struct {
+	original []rune
+	add      []rune
+	root     *pieceNode
+}
+
+type pieceNode struct {
+	piece
+	left, right *pieceNode
+	height      int
+	size        int // 子树内所有 piece 的 length 之和
+}
+
+func NewPieceTree(initial string) *PieceTree {
+	r := []rune(initial)
+	t := &PieceTree{original: r}
+	if len(r) > 0 {
+		t.root = newPieceNode(piece{buf: bufOriginal, offset: 0, length: len(r)})
+	}
+	return t
+}
+
+// NewPieceTreeFromDelta 从带样式的文档（只含 insert 的 delta，例如快照）构建，文本放进 original 缓冲区
+func NewPieceTreeFromDelta(doc delta.Delta) *PieceTree {
+	t := &PieceTree{}
+	for _, op := range doc {
+		if op.Kind != delta.KindInsert || op.Text == "" {
+			continue
+		}
+		r := []rune(op.Text)
+		node := newPieceNode(piece{buf: bufOriginal, offset: len(t.original), length: len(r), attrs: delta.ComposeAttrs(nil, op.Attrs, false)})
+		t.original = append(t.original, r...)
+		t.root = join2(t.root, node)
+	}
+	return t
+}
+
+func (t *PieceTree) Len() int {
+	return nodeSize(t.root)
+}
+
+func (t *PieceTree) String() string {
+	var sb strings.Builder
+	sb.Grow(t.Len())
+	t.walk(t.root, func(p piece) {
+		for _, r := range t.runes(p) {
+			sb.WriteRune(r)
+		}
+	})
+	return sb.String()
+}
+
+func (t *PieceTree) Delta() delta.Delta {
+	var doc delta.Delta
+	t.walk(t.root, func(p piece) {
+		doc = append(doc, delta.Op{Kind: delta.KindInsert, Text: string(t.runes(p)), Attrs: p.attrs})
+	})
+	return delta.Normalize(doc)
+}
+
+// Apply 与 PieceTable.Apply 的校验规则相同：先整体校验，失败时树保持不变
+func (t *PieceTree) Apply(d delta.Delta) error {
+	if err := validateDelta(d, t.Len()); err != nil {
+		return err
+	}
+	pos := 0
+	for _, op := range d {
+		switch op.Kind {
+		case delta.KindRetain:
+			if len(op.Attrs) > 0 {
+				t.format(pos, op.Count, op.Attrs)
+			}
+			pos += op.Count
+
+		case delta.KindInsert:
+			r := []rune(op.Text)
+			start := len(t.add)
+			t.add = append(t.add, r...)
+			node := newPieceNode(piece{buf: bufAdd, offset: start, length: len(r), attrs: delta.ComposeAttrs(nil, op.Attrs, false)})
+			left, right := t.split(t.root, pos)
+			t.root = join(left, node, right)
+			pos += len(r)
+
+		case delta.KindDelete:
+			left, rest := t.split(t.root, pos)
+			_, right := t.split(rest, op.Count)
+			t.root = join2(left, right)
+		}
+	}
+	return nil
+}
+
+// 对 [pos, pos+length) 范围内的文本合并样式属性
+func (t *PieceTree) format(pos, length int, attrs map[string]any) {
+	left, rest := t.split(t.root, pos)
+	mid, right := t.split(rest, length)
+	// 样式不影响长度，原地修改中间子树即可
+	var update func(n *pieceNode)
+	update = func(n *pieceNode) {
+		if n == nil {
+			return
+		}
+		update(n.left)
+		n.attrs = delta.ComposeAttrs(n.attrs, attrs, false)
+		update(n.right)
+	}
+	update(mid)
+	t.root = join2(join2(left, mid), right)
+}
+
+func (t *PieceTree) runes(p piece) []rune {
+	if p.buf == bufAdd {
+		return t.add[p.offset : p.offset+p.length]
+	}
+	return t.original[p.offset : p.offset+p.length]
+}
+
+// 中序遍历
+func (t *PieceTree) walk(n *pieceNode, fn func(piece)) {
+	if n == nil {
+		return
+	}
+	t.walk(n.left, fn)
+	fn(n.piece)
+	t.walk(n.right, fn)
+}
+
+// split 把子树按位置 pos 切成两棵：左边恰好包含前 pos 个字符。
+// pos 落在某个 piece 内部时把该 piece 一分为二。
+func (t *PieceTree) split(n *pieceNode, pos int) (*pieceNode, *pieceNode) {
+	if n == nil {
+		return nil, nil
+	}
+	leftSize := nodeSize(n.left)
+	switch {
+	case pos <= leftSize:
+		ll, lr := t.split(n.left, pos)
+		return ll, join(lr, n, n.right)
+	case pos >= leftSize+n.length:
+		rl, rr := t.split(n.right, pos-leftSize-n.length)
+		return join(n.left, n, rl), rr
+	default:
+		offset := pos - leftSize
+		leftPiece := piece{buf: n.buf, offset: n.offset, length: offset, attrs: n.attrs}
+		rightPiece := piece{buf: n.buf, offset: n.offset + offset, length: n.length - offset, attrs: n.attrs}
+		return join(n.left, newPieceNode(leftPiece), nil), join(nil, newPieceNode(rightPiece), n.right)
+	}
+}
+
+func newPieceNode(p piece) *pieceNode {
+	return &pieceNode{piece: p, height: 1, size: p.length}
+}
+
+func nodeSize(n *pieceNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func nodeHeight(n *pieceNode) int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *pieceNode) update() {
+	n.height = max(nodeHeight(n.left), nodeHeight(n.right)) + 1
+	n.size = nodeSize(n.left) + n.length + nodeSize(n.right)
+}
+
+func rotateLeft(n *pieceNode) *pieceNode {
+	r := n.right
+	n.right = r.left
+	n.update()
+	r.left = n
+	r.update()
+	return r
+}
+
+func rotateRight(n *pieceNode) *pieceNode {
+	l := n.left
+	n.left = l.right
+	n.update()
+	l.right = n
+	l.update()
+	return l
+}
+
+func rebalance(n *pieceNode) *pieceNode {
+	n.update()
+	switch bf := nodeHeight(n.left) - nodeHeight(n.right); {
+	case bf > 1:
+		if nodeHeight(n.left.left) < nodeHeight(n.left.right) {
+			n.left = rotateLeft(n.left)
+		}
+		return rotateRight(n)
+	case bf < -1:
+		if nodeHeight(n.right.right) < nodeHeight(n.right.left) {
+			n.right = rotateRight(n.right)
+		}
+		return rotateLeft(n)
+	}
+	return n
+}
+
+// join 以 mid 为中间节点拼接 left、right（left 的内容全部在 right 之前），结果保持 AVL 平衡
+func join(left, mid, right *pieceNode) *pieceNode {
+	switch {
+	case nodeHeight(left) > nodeHeight(right)+1:
+		left.right = join(left.right, mid, right)
+		return rebalance(left)
+	case nodeHeight(right) > nodeHeight(left)+1:
+		right.left = join(left, mid, right.left)
+		return rebalance(right)
+	}
+	mid.left, mid.right = left, right
+	mid.update()
+	return mid
+}
+
+// join2 拼接两棵树（没有中间节点时，借用 left 的最后一个节点）
+func join2(left, right *pieceNode) *pieceNode {
+	if left == nil {
+		return right
+	}
+	rest, last := splitLast(left)
+	return join(rest, last, right)
+}
+
+// 摘下子树中最后一个节点，返回剩余子树与该节点
+func splitLast(n *pieceNode) (*pieceNode, *pieceNode) {
+	if n.right == nil {
+		return n.left, n
+	}
+	rest, last := splitLast(n.right)
+	return join(n.left, n, rest), last
+}
@@ -0,0 +1,93 @@
+package collab
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+// 随机生成一个对长度为 length 的文档合法的 delta
+func randomDelta(rng *rand.Rand, length int) delta.Delta {
+	pos := rng.Intn(length + 1)
+	d := delta.Delta{}
+	if pos > 0 {
+		d = append(d, delta.Op{Kind: delta.KindRetain, Count: pos})
+	}
+	switch rng.Intn(3) {
+	case 0:
+		if pos < length {
+			d = append(d, delta.Op{Kind: delta.KindDelete, Count: 1 + rng.Intn(min(8, length-pos))})
+			break
+		}
+		fallthrough
+	case 1:
+		words := []string{"a", "编辑", "hello ", "协作文档"}
+		d = append(d, delta.Op{Kind: delta.KindInsert, Text: words[rng.Intn(len(words))]})
+	default:
+		if pos < length {
+			d = append(d, delta.Op{Kind: delta.KindRetain, Count: 1 + rng.Intn(min(8, length-pos)), Attrs: map[string]any{"bold": rng.Intn(2) == 0}})
+		} else {
+			d = append(d, delta.Op{Kind: delta.KindInsert, Text: "x"})
+		}
+	}
+	return d
+}
+
+func TestPieceTree_MatchesPieceTable(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	table := NewPieceTable("Hello world")
+	tree := NewPieceTree("Hello world")
+
+	for i := 0; i < 2000; i++ {
+		d := randomDelta(rng, table.Len())
+		if err := table.Apply(d); err != nil {
+			t.Fatalf("step %d: PieceTable.Apply(%+v) error = %v", i, d, err)
+		}
+		if err := tree.Apply(d); err != nil {
+			t.Fatalf("step %d: PieceTree.Apply(%+v) error = %v", i, d, err)
+		}
+		if tree.Len() != table.Len() {
+			t.Fatalf("step %d: Len() = %d, want %d", i, tree.Len(), table.Len())
+		}
+	}
+	if got, want := tree.String(), table.String(); got != want {
+		t.Fatalf("String() = %q, want %q", got, want)
+	}
+	if got, want := tree.Delta(), table.Delta(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Delta() mismatch:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestPieceTree_ApplyRejectsInvalidDelta(t *testing.T) {
+	tree := NewPieceTree("Hello")
+	d := delta.Delta{{Kind: delta.KindInsert, Text: ">"}, {Kind: delta.KindDelete, Count: 6}}
+	if err := tree.Apply(d); err == nil {
+		t.Fatalf("Apply() error = nil, want error")
+	}
+	if got := tree.String(); got != "Hello" {
+		t.Fatalf("String() = %q after rejected Apply, want unchanged", got)
+	}
+}
+
+// 模拟在长文档中随机位置连续打字
+func benchmarkBuffer(b *testing.B, newBuf func(string) Buffer) {
+	rng := rand.New(rand.NewSource(1))
+	buf := newBuf("")
+	for i := 0; i < 5000; i++ {
+		_ = buf.Apply(randomDelta(rng, buf.Len()))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = buf.Apply(randomDelta(rng, buf.Len()))
+	}
+}
+
+func BenchmarkPieceTable_Apply(b *testing.B) {
+	benchmarkBuffer(b, func(s string) Buffer { return NewPieceTable(s) })
+}
+
+func BenchmarkPieceTree_Apply(b *testing.B) {
+	benchmarkBuffer(b, func(s string) Buffer { return NewPieceTree(s) })
+}
@@ -0,0 +1,63 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// EventPublisher：文档事件的出口。Submit 等主流程只调用 Publish，不关心事件最终去了哪里
+// （Kafka、Redis Streams、进程内 channel 或直接丢弃）。
+// Publish 应尽快返回：实现方自行缓冲 / 异步发送，ctx 到期时返回错误，调用方只记日志。
+type EventPublisher interface {
+	Publish(ctx context.Context, evt DocEvent) error
+}
+
+// BoundedPublisher：Publish 只是放进有界进程内队列的出口，队列满时 emit 最多等待 PublishTimeout，
+// 不让满队列拖住 sequencer。没有实现它的出口（outbox、Redis Streams 等持久化出口）直接使用调用方的 ctx
+type BoundedPublisher interface {
+	EventPublisher
+	PublishTimeout() time.Duration
+}
+
+// 进程内队列满时 emit 的最长等待
+const inMemoryPublishTimeout = 50 * time.Millisecond
+
+// Publish 让 KafkaDispatcher 满足 EventPublisher：放入本地队列，由 worker 异步发送
+func (d *KafkaDispatcher) Publish(ctx context.Context, evt DocEvent) error {
+	return d.Enqueue(ctx, evt)
+}
+
+func (d *KafkaDispatcher) PublishTimeout() time.Duration { return inMemoryPublishTimeout }
+
+// NopPublisher 丢弃所有事件（不需要下游消费时使用）
+type NopPublisher struct{}
+
+func (NopPublisher) Publish(ctx context.Context, evt DocEvent) error { return nil }
+
+var ErrPublisherFull = errors.New("PUBLISHER_FULL")
+
+// ChannelPublisher 把事件放进进程内 channel，供测试与本地开发直接读取
+type ChannelPublisher struct {
+	ch chan DocEvent
+}
+
+func NewChannelPublisher(buffer int) *ChannelPublisher {
+	return &ChannelPublisher{ch: make(chan DocEvent, buffer)}
+}
+
+// Publish channel 满时等待到 ctx 到期，返回 ErrPublisherFull
+func (p *ChannelPublisher) Publish(ctx context.Context, evt DocEvent) error {
+	select {
+	case p.ch <- evt:
+		return nil
+	case <-ctx.Done():
+		return ErrPublisherFull
+	}
+}
+
+func (p *ChannelPublisher) PublishTimeout() time.Duration { return inMemoryPublishTimeout }
+
+func (p *ChannelPublisher) Events() <-chan DocEvent {
+	return p.ch
+}
@@ -0,0 +1,104 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+func TestInMemoryService_PublishesEventsWithoutKafka(t *testing.T) {
+	events := NewChannelPublisher(8)
+	svc := NewInMemoryService(&fakeSnapshotStore{}, nil, nil, events)
+	ctx := context.Background()
+
+	applied, err := svc.Submit(ctx, "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "hi"}})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if err := svc.SaveSnapshot(ctx, "doc"); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	op := <-events.Events()
+	var payload OpAppliedPayload
+	if err := json.Unmarshal(op.Payload, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if op.EventType != EventOpApplied || op.EventID != applied.OperationId || op.SchemaVersion != EventSchemaVersion || payload.Revision != 1 {
+		t.Fatalf("first event = %+v (payload %+v), want OP_APPLIED rev 1", op, payload)
+	}
+	if snap := <-events.Events(); snap.EventType != EventSnapshotSaved || snap.DocID != "doc" {
+		t.Fatalf("second event = %+v, want SNAPSHOT_SAVED", snap)
+	}
+}
+
+// 持久化出口：比进程内队列的等待上限慢，只在 ctx 仍有效时记下事件
+type slowDurablePublisher struct {
+	delay time.Duration
+	got   chan DocEvent
+}
+
+func (p *slowDurablePublisher) Publish(ctx context.Context, evt DocEvent) error {
+	select {
+	case <-time.After(p.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	p.got <- evt
+	return nil
+}
+
+func TestInMemoryService_DurablePublisherUsesCallerContext(t *testing.T) {
+	pub := &slowDurablePublisher{delay: 2 * inMemoryPublishTimeout, got: make(chan DocEvent, 1)}
+	svc := NewInMemoryService(nil, nil, nil, pub)
+
+	if _, err := svc.Submit(context.Background(), "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "hi"}}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	select {
+	case evt := <-pub.got:
+		if evt.EventType != EventOpApplied {
+			t.Fatalf("event = %+v, want OP_APPLIED", evt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event dropped by the in-memory publish timeout")
+	}
+}
+
+// 有界出口：记录 Publish 拿到的 ctx 期限
+type fakeBoundedPublisher struct {
+	timeout   time.Duration
+	remaining chan time.Duration
+}
+
+func (p *fakeBoundedPublisher) Publish(ctx context.Context, evt DocEvent) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		p.remaining <- 0
+		return nil
+	}
+	p.remaining <- time.Until(deadline)
+	return nil
+}
+
+func (p *fakeBoundedPublisher) PublishTimeout() time.Duration { return p.timeout }
+
+func TestInMemoryService_BoundedPublisherGetsItsTimeout(t *testing.T) {
+	pub := &fakeBoundedPublisher{timeout: 5 * time.Second, remaining: make(chan time.Duration, 1)}
+	svc := NewInMemoryService(nil, nil, nil, pub)
+
+	if _, err := svc.Submit(context.Background(), "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "hi"}}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	select {
+	case remaining := <-pub.remaining:
+		if remaining <= 4*time.Second || remaining > 5*time.Second {
+			t.Fatalf("Publish() ctx deadline in %v, want about %v", remaining, pub.timeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not published")
+	}
+}
@@ -0,0 +1,164 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// 每个常驻文档由一个 sequencer goroutine 独占：Submit / 读取 / 快照等都以命令的形式投递到它的 inbox，
+// 按到达顺序逐个执行，docState 只在这个 goroutine 里读写，不需要加锁。
+// 事件发布与 op 监听器放在独立的 emitter goroutine 中按 revision 顺序执行，不占用 sequencer。
+
+// sequencer 已退出（文档被淘汰 / 丢弃或加载失败），调用方应重新获取文档
+var errDocRetired = errors.New("DOC_RETIRED")
+
+// emitter 缓冲的输出条数；满了之后 sequencer 会等待，形成背压
+const docOutboundSize = 256
+
+// 统计类接口查询单个文档的等待上限，忙的文档直接跳过
+const peekTimeout = 100 * time.Millisecond
+
+type docCommand struct {
+	ctx  context.Context
+	fn   func(ds *docState) error
+	done chan error
+}
+
+// sequencer 交给 emitter 的输出
+type docOutput struct {
+	event *DocEvent  // 需要发布的事件，nil 表示没有（outbox 模式下事件已随数据落库）
+	op    *AppliedOp // 新应用的操作，交给 op 监听器
+}
+
+// 在文档的 sequencer 中执行 fn。命令一旦被接收就等待它执行完（fn 内的 IO 使用 ctx，会随之取消）。
+func (ds *docState) do(ctx context.Context, fn func(ds *docState) error) error {
+	cmd := docCommand{ctx: ctx, fn: fn, done: make(chan error, 1)}
+	select {
+	case ds.inbox <- cmd:
+	case <-ds.stopped:
+		return errDocRetired
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-cmd.done
+}
+
+// 只读查询，文档仍在加载、加载失败或在 peekTimeout 内排不上队时返回 false
+func (ds *docState) peek(fn func(ds *docState)) bool {
+	select {
+	case <-ds.ready:
+	default:
+		return false
+	}
+	if ds.loadErr != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), peekTimeout)
+	defer cancel()
+	return ds.do(ctx, func(ds *docState) error {
+		fn(ds)
+		return nil
+	}) == nil
+}
+
+// 通知 sequencer 在当前命令之后退出，可重复调用
+func (ds *docState) retire() {
+	ds.retireOnce.Do(func() { close(ds.retired) })
+}
+
+// 由 sequencer 调用：把输出交给 emitter
+func (ds *docState) output(out docOutput) {
+	ds.outbound <- out
+}
+
+// 获取文档并在它的 sequencer 中执行 fn；文档恰好被淘汰时重新获取（会触发重新加载）
+func (s *InMemoryService) exec(ctx context.Context, docID string, fn func(ds *docState) error) error {
+	for {
+		ds, err := s.getOrCreateDoc(ctx, docID)
+		if err != nil {
+			return err
+		}
+		if err := ds.do(ctx, fn); !errors.Is(err, errDocRetired) {
+			return err
+		}
+	}
+}
+
+// 文档的 sequencer：先加载，再逐个执行命令，直到 retire
+func (s *InMemoryService) runSequencer(ctx context.Context, docID string, ds *docState, prevEmitter <-chan struct{}) {
+	defer close(ds.stopped)
+	s.hydrate(ctx, docID, ds)
+	if ds.loadErr != nil {
+		close(ds.emitted)
+		s.forgetEmitter(docID, ds)
+		return
+	}
+	go s.runEmitter(docID, ds, prevEmitter)
+	defer close(ds.outbound)
+
+	for {
+		// retire 优先：之后到达的命令不再执行，调用方会重新获取文档
+		select {
+		case <-ds.retired:
+			return
+		default:
+		}
+		select {
+		case <-ds.retired:
+			return
+		case cmd := <-ds.inbox:
+			if err := cmd.ctx.Err(); err != nil {
+				cmd.done <- err
+				continue
+			}
+			cmd.done <- cmd.fn(ds)
+		}
+	}
+}
+
+// 按 sequencer 产生的顺序发布事件、调用 op 监听器。
+// 同一文档淘汰后重新加载时，等上一个 emitter 处理完再开始，保证跨实例化也按 revision 顺序。
+func (s *InMemoryService) runEmitter(docID string, ds *docState, prev <-chan struct{}) {
+	defer s.forgetEmitter(docID, ds)
+	defer close(ds.emitted)
+	if prev != nil {
+		<-prev
+	}
+	for out := range ds.outbound {
+		if out.event != nil {
+			s.emit(context.Background(), *out.event)
+		}
+		if out.op != nil {
+			for _, fn := range s.opListeners {
+				fn(docID, *out.op)
+			}
+		}
+	}
+}
+
+// 创建 docState 时调用（持有 s.mu）：登记新的 emitter，返回同一文档上一个尚未结束的 emitter
+func (s *InMemoryService) trackEmitterLocked(docID string, ds *docState) <-chan struct{} {
+	prev := s.emitters[docID]
+	s.emitters[docID] = ds.emitted
+	return prev
+}
+
+func (s *InMemoryService) forgetEmitter(docID string, ds *docState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.emitters[docID] == ds.emitted {
+		delete(s.emitters, docID)
+	}
+}
+
+// 常驻文档的快照（不持有 s.mu 去访问各个 sequencer）
+func (s *InMemoryService) liveDocs() map[string]*docState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	docs := make(map[string]*docState, len(s.docs))
+	for id, ds := range s.docs {
+		docs[id] = ds
+	}
+	return docs
+}
@@ -0,0 +1,89 @@
+package collab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+func TestInMemoryService_ListenerAndEventsInRevisionOrder(t *testing.T) {
+	events := NewChannelPublisher(256)
+	var mu sync.Mutex
+	var seen []uint64
+	svc := NewInMemoryService(nil, nil, nil, events, WithOpListener(func(docID string, op AppliedOp) {
+		mu.Lock()
+		seen = append(seen, op.Revision)
+		mu.Unlock()
+	}))
+	ctx := context.Background()
+
+	// 多个客户端并发基于 revision 0 提交，由 sequencer 排序并做 OT 变换
+	const clients, perClient = 8, 10
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < perClient; i++ {
+				ops := delta.Delta{{Kind: delta.KindInsert, Text: "x"}}
+				if _, err := svc.Submit(ctx, "doc", 1, 0, fmt.Sprintf("c%d", c), uint64(i+1), ops); err != nil {
+					t.Errorf("Submit(c%d, %d) error = %v", c, i, err)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	for want := uint64(1); want <= clients*perClient; want++ {
+		select {
+		case evt := <-events.Events():
+			var payload OpAppliedPayload
+			if err := json.Unmarshal(evt.Payload, &payload); err != nil || payload.Revision != want {
+				t.Fatalf("event #%d revision = %d (%v), want %d", want, payload.Revision, err, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event for revision %d not published", want)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, rev := range seen {
+		if rev != uint64(i+1) {
+			t.Fatalf("listener revisions = %v, want 1..%d in order", seen, clients*perClient)
+		}
+	}
+}
+
+// 阻塞直到 release 关闭的事件出口
+type blockingPublisher struct {
+	release chan struct{}
+}
+
+func (p *blockingPublisher) Publish(ctx context.Context, evt DocEvent) error {
+	<-p.release
+	return nil
+}
+
+func TestInMemoryService_SlowPublisherDoesNotBlockSubmit(t *testing.T) {
+	pub := &blockingPublisher{release: make(chan struct{})}
+	defer close(pub.release)
+	svc := NewInMemoryService(nil, nil, nil, pub)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := uint64(0); i < 20; i++ {
+		ops := delta.Delta{{Kind: delta.KindInsert, Text: "x"}}
+		if _, err := svc.Submit(ctx, "doc", 1, i, "c1", i+1, ops); err != nil {
+			t.Fatalf("Submit(%d) error = %v", i, err)
+		}
+	}
+	if _, rev, err := svc.LoadDocumentContent(ctx, "doc"); err != nil || rev != 20 {
+		t.Fatalf("LoadDocumentContent() rev = %d, %v; want 20", rev, err)
+	}
+}
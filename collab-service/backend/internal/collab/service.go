@@ -6,15 +6,17 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/IBM/sarama"
-
+	"collabServer/backend/internal/idgen"
 	"collabServer/backend/internal/ot/delta"
 )
 
 // 协作引擎接口
 type Service interface {
+	// 重复提交已应用过的 clientSeq 时返回原来的 AppliedOp 与 *DuplicateOpError；
+	// clientSeq 跳号时返回 *OutOfOrderError
 	Submit(ctx context.Context, docID string, authorID uint64,
 		baseRevision uint64, clientID string, clientSeq uint64,
 		ops delta.Delta) (AppliedOp, error)
@@ -23,31 +25,82 @@ type Service interface {
 
 	LoadDocumentContent(ctx context.Context, docID string) (string, uint64, error)
 
+	// 带样式属性的文档内容（只含 insert 的 delta）
+	LoadDocumentDelta(ctx context.Context, docID string) (delta.Delta, uint64, error)
+
 	// 用于握手/追平
 	OpsSince(ctx context.Context, docID string, fromRevision uint64, limit int) ([]AppliedOp, error)
 
 	SaveSnapshot(ctx context.Context, docID string) error
 
+	// 快照历史：按版本倒序列出、读取某个快照内容、回滚到某个快照
+	ListSnapshots(ctx context.Context, docID string, limit int) ([]SnapshotInfo, error)
+	GetSnapshot(ctx context.Context, docID string, rev uint64) (string, error)
+	// 以一个新版本的形式把文档内容恢复为快照 rev 的内容，返回该次操作
+	RestoreSnapshot(ctx context.Context, docID string, authorID uint64, rev uint64) (AppliedOp, error)
+
+	// 文档在任意历史版本 rev 时带样式的内容：最近的快照 + 重放操作日志里之后的操作
+	ContentAtRevision(ctx context.Context, docID string, rev uint64) (delta.Delta, error)
+
+	// 各内存文档距上次快照的滞后情况（供快照调度与监控）
+	SnapshotLags() []SnapshotLag
+
+	// 按淘汰策略把空闲/超出预算的文档快照后移出内存，返回淘汰数量
+	EvictIdle(ctx context.Context, opt EvictionOptions) (int, error)
+	CacheStats() CacheStats
+
 	GetDocumentID(ctx context.Context, title string) (string, error)
 
-	CreateDocument(ctx context.Context, ownerID uint64, title string) error
+	// 创建文档，返回新文档 ID
+	CreateDocument(ctx context.Context, ownerID uint64, title string) (string, error)
+
+	// 用户在文档上的第一个连接加入（joined=true）/ 最后一个连接离开时调用，发出成员事件
+	RecordMembership(ctx context.Context, docID string, userID uint64, username string, joined bool)
 }
 
-// 快照存储接口
+// 快照存储接口。快照保存带样式的完整文档（只含 insert 的 delta），重新加载后样式不丢失
 type SnapshotStore interface {
-	SaveDocumentSnapshot(ctx context.Context, docID string, rev uint64, content string) error
+	// fence 见 OpLogStore.AppendOp
+	SaveDocumentSnapshot(ctx context.Context, docID string, rev uint64, doc delta.Delta, fence uint64) error
+	// 读取最新一条快照；文档没有任何快照时返回 (nil, 0, nil)
+	LoadLatestSnapshot(ctx context.Context, docID string) (doc delta.Delta, rev uint64, err error)
+	// 按版本倒序列出快照元信息，limit <= 0 表示不限制条数
+	ListSnapshots(ctx context.Context, docID string, limit int) ([]SnapshotInfo, error)
+	// 读取指定版本快照的纯文本；不存在时返回 ErrSnapshotNotFound
+	LoadSnapshot(ctx context.Context, docID string, rev uint64) (string, error)
+	// 读取版本 <= rev 的最近一条快照；没有时返回 (nil, 0, nil)，即从空文档开始
+	LoadSnapshotAtOrBefore(ctx context.Context, docID string, rev uint64) (doc delta.Delta, snapRev uint64, err error)
+}
+
+// 快照元信息
+type SnapshotInfo struct {
+	Revision  uint64    `json:"revision"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int       `json:"size"` // 内容长度（字符数）
+}
+
+// 操作日志存储接口：持久化每一条 AppliedOp，OpsSince 在环形缓冲覆盖不到时回退到这里
+type OpLogStore interface {
+	// fence 为写入方持有的租约 token（0 表示不启用）：该文档已见过更大的 token 时拒绝写入并返回 ErrFenced，
+	// 丢了租约的旧 owner 因此无法覆盖新 owner 的数据
+	AppendOp(ctx context.Context, docID string, op AppliedOp, fence uint64) error
+	// 按版本升序返回 fromRevision 之后的操作，limit <= 0 表示不限制条数
+	LoadOps(ctx context.Context, docID string, fromRevision uint64, limit int) ([]AppliedOp, error)
 }
 
 type DocumentStore interface {
 	GetDocumentID(ctx context.Context, title string) (string, error)
-	CreateDocument(ctx context.Context, ownerID uint64, title string) error
+	CreateDocument(ctx context.Context, docID string, ownerID uint64, title string) error
 }
 
 type AppliedOp struct {
-	OperationId string // 本次操作的唯一ID（用于幂等/追踪）
+	OperationId string // 本次操作的全局唯一ID（Snowflake，跨实例不重复，供下游去重/追踪）
 	Revision    uint64 // 全局版本号
 	AuthorId    uint64
+	ClientId    string // 提交该操作的客户端实例
+	ClientSeq   uint64 // 该客户端的本地递增序号
 	// 用户操作序列，注意不是[]
+	// 若提交时 baseRevision 落后，这里是经过 OT 变换后实际应用的 ops
 	Ops       delta.Delta
 	AppliedAt time.Time
 }
@@ -55,16 +108,68 @@ type AppliedOp struct {
 var (
 	ErrRevisionConflict      = errors.New("REVISION_CONFLICT")
 	ErrDuplicateOrOutOfOrder = errors.New("DUPLICATE_OR_OUT_OF_ORDER")
+	ErrSnapshotNotFound      = errors.New("SNAPSHOT_NOT_FOUND")
+	ErrRevisionNotFound      = errors.New("REVISION_NOT_FOUND")
+	ErrHistoryUnavailable    = errors.New("HISTORY_UNAVAILABLE")
+	// baseRevision 落后当前版本超过 maxRebaseOps：不再逐个变换，客户端应重新同步后再提交
+	ErrBaseTooOld = errors.New("BASE_TOO_OLD")
 )
 
+// 每个 clientId 记住最近多少条已应用操作，用于重复提交时原样返回结果
+const dedupWindow = 64
+
+// 重复提交（ack 丢失后的重试）：Op 为该 clientSeq 第一次提交时应用的结果，调用方应重发 ack 而不是再广播
+type DuplicateOpError struct {
+	Op AppliedOp
+}
+
+func (e *DuplicateOpError) Error() string {
+	return fmt.Sprintf("DUPLICATE_OP: clientSeq %d already applied at revision %d", e.Op.ClientSeq, e.Op.Revision)
+}
+
+// clientSeq 跳号：中间有操作丢失，客户端需要从 ExpectedSeq 开始按顺序重发
+type OutOfOrderError struct {
+	ClientId    string
+	ExpectedSeq uint64
+	GotSeq      uint64
+}
+
+func (e *OutOfOrderError) Error() string {
+	return fmt.Sprintf("OUT_OF_ORDER: client %s expected clientSeq %d, got %d", e.ClientId, e.ExpectedSeq, e.GotSeq)
+}
+
+// 文档状态，只在该文档的 sequencer goroutine 中读写（见 sequencer.go）
 type docState struct {
-	mu       sync.RWMutex
 	revision uint64
 	opsRing  []AppliedOp
-	// 去重窗口：记录某 clientId (string) 最近的最大 clientSeq (uint64)（或滑动窗口集合）
+	// 去重窗口：记录某 clientId (string) 最近的最大 clientSeq (uint64)，
+	// 以及该 client 最近 dedupWindow 条操作的应用结果（按 clientSeq 升序）
 	lastSeqByClient map[string]uint64
+	recentByClient  map[string][]AppliedOp
 	// 文档内容缓冲区
 	buf Buffer
+
+	// 最近一次成功快照的版本；dirtySince 为快照之后第一次修改的时间
+	snapshotRev uint64
+	dirtySince  time.Time
+
+	// 首次加载快照完成后关闭；loadErr 非空表示加载失败，该 docState 不可用
+	ready   chan struct{}
+	loadErr error
+
+	// 最近一次被访问的时间（UnixNano），用于空闲淘汰与 LRU
+	lastAccess atomic.Int64
+	// 加载该状态时持有的租约 token（启用 Ownership 时）
+	leaseToken uint64
+
+	// sequencer 的命令队列；retired 关闭后 sequencer 退出并关闭 stopped
+	inbox      chan docCommand
+	retired    chan struct{}
+	retireOnce sync.Once
+	stopped    chan struct{}
+	// sequencer -> emitter 的有序输出；emitter 处理完后关闭 emitted
+	outbound chan docOutput
+	emitted  chan struct{}
 }
 
 // 内存实现：持有所有文档的状态
@@ -72,171 +177,475 @@ type InMemoryService struct {
 	mu      sync.RWMutex
 	docs    map[string]*docState
 	ringCap int
+	// 提交时 baseRevision 最多落后多少个版本，超过时返回 ErrBaseTooOld，避免在 sequencer 里读取和变换大量历史
+	maxRebaseOps int
 
 	// 依赖注入
 	// 只声明，实现在store中
 	store         SnapshotStore
+	opLog         OpLogStore
 	documentStore DocumentStore
 
-	kafka      sarama.SyncProducer
-	kafkaTopic string
+	// 文档事件出口（Kafka / Redis Streams / channel / 丢弃）
+	events EventPublisher
 
-	kafkaDispatcher *KafkaDispatcher
+	// 多实例文档归属，nil 表示单实例部署
+	ownership Ownership
+
+	// 操作 ID / 文档 ID 生成器，多实例部署时每个实例配置不同的节点 ID
+	ids *idgen.Generator
+
+	// outbox 模式（非 nil 时事件与操作日志 / 快照同一事务落库，由 OutboxRelay 发布）
+	outboxOps       TransactionalOpLog
+	outboxSnapshots TransactionalSnapshotStore
+
+	// 每个文档的 op 监听器，在 emitter 中按 revision 顺序调用
+	opListeners []func(docID string, op AppliedOp)
+	// 各文档最近一个 emitter 的结束信号，重新加载时新 emitter 等旧的处理完
+	emitters map[string]chan struct{}
+
+	// 淘汰相关计数；evictedDocs 记录被淘汰、尚未重新加载的文档
+	evictions   atomic.Uint64
+	reloads     atomic.Uint64
+	evictedMu   sync.Mutex
+	evictedDocs map[string]struct{}
 }
 
-// NewInMemoryService 返回一个满足 Service 接口的实例
-func NewInMemoryService(store SnapshotStore, documentStore DocumentStore, kafka sarama.SyncProducer, kafkaTopic string, kafkaDispatcher *KafkaDispatcher) Service {
-	return &InMemoryService{
-		docs:            make(map[string]*docState),
-		ringCap:         1024, // 近期操作环形缓冲容量，可按需调整
-		store:           store,
-		documentStore:   documentStore,
-		kafka:           kafka,
-		kafkaTopic:      kafkaTopic,
-		kafkaDispatcher: kafkaDispatcher,
+// NewInMemoryService 返回一个满足 Service 接口的实例；events 为 nil 时不发出事件
+func NewInMemoryService(store SnapshotStore, opLog OpLogStore, documentStore DocumentStore, events EventPublisher, opts ...ServiceOption) Service {
+	if events == nil {
+		events = NopPublisher{}
+	}
+	s := &InMemoryService{
+		docs:          make(map[string]*docState),
+		evictedDocs:   make(map[string]struct{}),
+		emitters:      make(map[string]chan struct{}),
+		ringCap:       1024, // 近期操作环形缓冲容量，可按需调整
+		maxRebaseOps:  1024,
+		store:         store,
+		opLog:         opLog,
+		documentStore: documentStore,
+		events:        events,
 	}
+	s.ids, _ = idgen.New(0)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *InMemoryService) LoadDocumentContent(ctx context.Context, docID string) (string, uint64, error) {
+	var content string
+	var rev uint64
+	err := s.exec(ctx, docID, func(ds *docState) error {
+		content, rev = ds.buf.String(), ds.revision
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return content, rev, nil
+}
+
+func (s *InMemoryService) LoadDocumentDelta(ctx context.Context, docID string) (delta.Delta, uint64, error) {
+	var d delta.Delta
+	var rev uint64
+	err := s.exec(ctx, docID, func(ds *docState) error {
+		d, rev = ds.buf.Delta(), ds.revision
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return d, rev, nil
+}
+
+// 获取或创建指定文档的状态。
+// 文档第一次被访问时启动它的 sequencer，由 sequencer 从快照存储加载最新快照（内容 + 版本）；
+// 并发的首次访问只有一个会真正读库，其余等待 ready 关闭后直接复用结果。
+func (s *InMemoryService) getOrCreateDoc(ctx context.Context, docID string) (*docState, error) {
+	// 多实例部署：只有 owner 能持有文档状态
+	var token uint64
+	if s.ownership != nil {
+		var err error
+		if token, err = s.ensureOwner(ctx, docID); err != nil {
+			return nil, err
+		}
+	}
+
 	s.mu.RLock()
 	ds := s.docs[docID]
 	s.mu.RUnlock()
 	if ds == nil {
-		return "", 0, errors.New("document not found")
+		s.mu.Lock()
+		if ds = s.docs[docID]; ds != nil {
+			s.mu.Unlock()
+		} else {
+			capacity := s.ringCap
+			if capacity <= 0 {
+				capacity = 1024
+			}
+			ds = &docState{
+				lastSeqByClient: make(map[string]uint64),
+				recentByClient:  make(map[string][]AppliedOp),
+				opsRing:         make([]AppliedOp, 0, capacity),
+				ready:           make(chan struct{}),
+				leaseToken:      token,
+				inbox:           make(chan docCommand),
+				retired:         make(chan struct{}),
+				stopped:         make(chan struct{}),
+				outbound:        make(chan docOutput, docOutboundSize),
+				emitted:         make(chan struct{}),
+			}
+			s.docs[docID] = ds
+			prev := s.trackEmitterLocked(docID, ds)
+			s.mu.Unlock()
+			go s.runSequencer(ctx, docID, ds, prev)
+		}
+	}
+
+	select {
+	case <-ds.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	ds.mu.RLock()
-	defer ds.mu.RUnlock()
-	return ds.buf.String(), ds.revision, nil
+	if ds.loadErr != nil {
+		return nil, ds.loadErr
+	}
+	if s.ownership != nil && ds.leaseToken != token {
+		// 租约中途丢失又重新获得：本地状态可能落后于其他实例写入的操作，丢弃后重新加载
+		s.discardDoc(docID, ds)
+		return s.getOrCreateDoc(ctx, docID)
+	}
+	ds.lastAccess.Store(time.Now().UnixNano())
+	return ds, nil
 }
 
-// 获取或创建指定文档的状态
-func (s *InMemoryService) getOrCreateDoc(docID string) *docState {
-	s.mu.RLock()
-	ds := s.docs[docID]
-	s.mu.RUnlock()
-	if ds != nil {
-		return ds
-	}
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if ds = s.docs[docID]; ds == nil {
-		capacity := s.ringCap
-		if capacity <= 0 {
-			capacity = 1024
+// 从最新快照初始化 ds，再从操作日志重放快照之后的操作（上一个 owner 未来得及快照的部分），
+// 完成后关闭 ds.ready。加载失败时把 ds 从 docs 中移除，下一次访问会重新加载。
+func (s *InMemoryService) hydrate(ctx context.Context, docID string, ds *docState) {
+	defer close(ds.ready)
+	fail := func(err error) {
+		log.Printf("load document failed doc=%s: %v", docID, err)
+		ds.loadErr = fmt.Errorf("load document %s: %w", docID, err)
+		s.mu.Lock()
+		if s.docs[docID] == ds {
+			delete(s.docs, docID)
+		}
+		s.mu.Unlock()
+	}
+
+	// 不跟随首个调用方的取消：其他等待者也依赖这次加载的结果
+	loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	var doc delta.Delta
+	var rev uint64
+	if s.store != nil {
+		var err error
+		if doc, rev, err = s.store.LoadLatestSnapshot(loadCtx, docID); err != nil {
+			fail(err)
+			return
+		}
+	}
+	buf := NewPieceTreeFromDelta(doc)
+	snapshotRev := rev
+	if s.opLog != nil {
+		// 快照之前的最近一段操作也读出来（最多一个环形缓冲容量），只用于重建环形缓冲与去重窗口：
+		// 淘汰 / 停机前刚快照过的文档，客户端重试快照前的操作时仍能识别为重复
+		from := rev - min(rev, uint64(cap(ds.opsRing)))
+		ops, err := s.opLog.LoadOps(loadCtx, docID, from, 0)
+		if err != nil {
+			fail(err)
+			return
 		}
-		ds = &docState{
-			lastSeqByClient: make(map[string]uint64),
-			opsRing:         make([]AppliedOp, 0, capacity),
-			buf:             NewPieceTable(""),
+		for _, op := range ops {
+			// 环形缓冲要求版本连续（rebase 按版本号下标访问），日志有缺口时从缺口之后重新开始
+			if n := len(ds.opsRing); n > 0 && ds.opsRing[n-1].Revision+1 != op.Revision {
+				ds.opsRing = ds.opsRing[:0]
+			}
+			if op.Revision <= snapshotRev {
+				ds.pushRing(op)
+				ds.rememberClientOp(op)
+				continue
+			}
+			if op.Revision != rev+1 {
+				fail(fmt.Errorf("%w: expected revision %d, got %d", ErrHistoryUnavailable, rev+1, op.Revision))
+				return
+			}
+			if err := buf.Apply(op.Ops); err != nil {
+				fail(fmt.Errorf("replay revision %d: %w", op.Revision, err))
+				return
+			}
+			rev = op.Revision
+			ds.pushRing(op)
+			// 重建去重窗口：客户端重试已应用过的操作时仍能识别出来
+			ds.rememberClientOp(op)
 		}
-		s.docs[docID] = ds
 	}
-	return ds
+	ds.buf = buf
+	ds.revision = rev
+	ds.snapshotRev = snapshotRev
+	if rev > snapshotRev {
+		ds.dirtySince = time.Now()
+	}
+
+	s.evictedMu.Lock()
+	if _, ok := s.evictedDocs[docID]; ok {
+		delete(s.evictedDocs, docID)
+		s.reloads.Add(1)
+	}
+	s.evictedMu.Unlock()
 }
 
-// 提交操作（InMemoryService 实现）
-func (s *InMemoryService) Submit(ctx context.Context, docID string, authorID uint64, baseRevision uint64, clientId string, clientSeq uint64, ops delta.Delta) (AppliedOp, error) {
-	ds := s.getOrCreateDoc(docID)
-	// 加锁，保护 ds 的并发访问（map）
-	ds.mu.Lock()
-	defer ds.mu.Unlock()
+// 保存到环形缓冲（如果达到容量则丢弃最老的一条）
+func (ds *docState) pushRing(op AppliedOp) {
+	if cap(ds.opsRing) > 0 && len(ds.opsRing) == cap(ds.opsRing) {
+		copy(ds.opsRing[0:], ds.opsRing[1:])
+		ds.opsRing = ds.opsRing[:len(ds.opsRing)-1]
+	}
+	ds.opsRing = append(ds.opsRing, op)
+}
+
+// 记录 client 的最新 clientSeq 与应用结果（服务端内部发起的操作没有 clientId，不记录）
+func (ds *docState) rememberClientOp(op AppliedOp) {
+	if op.ClientId == "" {
+		return
+	}
+	ds.lastSeqByClient[op.ClientId] = op.ClientSeq
+	recent := append(ds.recentByClient[op.ClientId], op)
+	if len(recent) > dedupWindow {
+		recent = recent[len(recent)-dedupWindow:]
+	}
+	ds.recentByClient[op.ClientId] = recent
+}
+
+// 按 clientSeq 做幂等校验：
+// - clientSeq 已处理过且仍在窗口内：返回 DuplicateOpError（携带原结果）
+// - 已处理过但超出窗口：无法给出原结果，返回 ErrDuplicateOrOutOfOrder
+// - 跳号：返回 OutOfOrderError，要求从 lastSeq+1 重发
+// 没见过的 client（新连接、文档淘汰后重新加载）接受任意起始序号。
+func (ds *docState) checkClientSeq(clientId string, clientSeq uint64) error {
+	if clientId == "" {
+		return nil
+	}
+	last, known := ds.lastSeqByClient[clientId]
+	if !known {
+		return nil
+	}
+	switch {
+	case clientSeq <= last:
+		for _, op := range ds.recentByClient[clientId] {
+			if op.ClientSeq == clientSeq {
+				return &DuplicateOpError{Op: op}
+			}
+		}
+		return ErrDuplicateOrOutOfOrder
+	case clientSeq > last+1:
+		return &OutOfOrderError{ClientId: clientId, ExpectedSeq: last + 1, GotSeq: clientSeq}
+	}
+	return nil
+}
 
-	// 幂等/去重（最小实现：只允许递增）
-	if last := ds.lastSeqByClient[clientId]; clientSeq <= last {
-		// 已处理过或乱序，最小实现可直接返回冲突
-		return AppliedOp{}, ErrDuplicateOrOutOfOrder
+// 把基于 baseRevision 的 ops 依次对 (baseRevision, revision] 之间已应用的操作做变换，
+// 得到可以直接应用在当前版本上的 ops。在 sequencer 中调用。
+func (s *InMemoryService) rebaseOps(ctx context.Context, docID string, ds *docState, baseRevision uint64, ops delta.Delta) (delta.Delta, error) {
+	var history []AppliedOp
+	if len(ds.opsRing) > 0 && ds.opsRing[0].Revision <= baseRevision+1 {
+		history = ds.opsRing[baseRevision+1-ds.opsRing[0].Revision:]
+	} else if s.opLog != nil {
+		// 环形缓冲覆盖不到（重启或淘汰后重新加载），从操作日志补齐；落后的条数已由 maxRebaseOps 限制
+		loaded, err := s.opLog.LoadOps(ctx, docID, baseRevision, int(ds.revision-baseRevision))
+		if err != nil {
+			return nil, err
+		}
+		history = loaded
+	}
+	// 必须完整拿到 baseRevision 之后的所有操作，否则无法变换
+	if uint64(len(history)) != ds.revision-baseRevision || history[0].Revision != baseRevision+1 {
+		return nil, ErrRevisionConflict
 	}
-	// 版本校验
-	if baseRevision != ds.revision {
-		return AppliedOp{}, ErrRevisionConflict
+	for _, applied := range history {
+		// 已应用的操作先发生，同位置插入时排在前面
+		ops = delta.Transform(applied.Ops, ops, true)
 	}
+	return ops, nil
+}
 
+// 提交操作（InMemoryService 实现）
+func (s *InMemoryService) Submit(ctx context.Context, docID string, authorID uint64, baseRevision uint64, clientId string, clientSeq uint64, ops delta.Delta) (AppliedOp, error) {
+	var applied AppliedOp
+	// 在文档的 sequencer 中执行：同一文档的提交严格按到达顺序分配 revision
+	err := s.exec(ctx, docID, func(ds *docState) error {
+		// 幂等/去重：重复提交返回原结果，跳号要求客户端重发
+		if err := ds.checkClientSeq(clientId, clientSeq); err != nil {
+			var dup *DuplicateOpError
+			if errors.As(err, &dup) {
+				applied = dup.Op
+			}
+			return err
+		}
+		// 版本校验：base 落后时基于 opsRing 做 OT 变换（rebase），base 超前或历史不完整则冲突，
+		// 落后太多要求客户端先重新同步
+		if baseRevision > ds.revision {
+			return ErrRevisionConflict
+		}
+		if ds.revision-baseRevision > uint64(s.maxRebaseOps) {
+			return ErrBaseTooOld
+		}
+		rebased := ops
+		if baseRevision < ds.revision {
+			transformed, err := s.rebaseOps(ctx, docID, ds, baseRevision, ops)
+			if err != nil {
+				return err
+			}
+			rebased = transformed
+		}
+		var err error
+		applied, err = s.applyOp(ctx, docID, ds, authorID, baseRevision, clientId, clientSeq, rebased)
+		return err
+	})
+	return applied, err
+}
+
+// 把已经基于当前版本的 ops 应用到文档上：写操作日志、推进版本、写环形缓冲，
+// 再把事件和操作交给 emitter（发布不占用 sequencer）。在 sequencer 中调用。
+func (s *InMemoryService) applyOp(ctx context.Context, docID string, ds *docState, authorID uint64,
+	baseRevision uint64, clientId string, clientSeq uint64, ops delta.Delta) (AppliedOp, error) {
 	if ds.buf == nil {
-		ds.buf = NewPieceTable("")
+		ds.buf = NewPieceTree("")
 	}
-	if err := ds.buf.Apply(ops); err != nil {
+	// 先校验再落操作日志，最后才修改内存状态：日志写失败时文档保持不变
+	if err := validateDelta(ops, ds.buf.Len()); err != nil {
 		return AppliedOp{}, err
 	}
-
-	// 推进版本
-	ds.revision++
 	appliedOp := AppliedOp{
-		OperationId: fmt.Sprintf("o-%d", time.Now().UnixNano()),
-		Revision:    ds.revision,
+		OperationId: s.ids.Next().String(),
+		Revision:    ds.revision + 1,
 		AuthorId:    authorID,
+		ClientId:    clientId,
+		ClientSeq:   clientSeq,
 		Ops:         ops,
 		AppliedAt:   time.Now(),
 	}
+	// 事件 ID 与操作 ID 相同：同一操作无论经过多少次重试、补发，消费方都能去重
+	evt, err := NewDocEvent(appliedOp.OperationId, EventOpApplied, docID, appliedOp.AppliedAt, OpAppliedPayload{
+		OperationID:  appliedOp.OperationId,
+		Revision:     appliedOp.Revision,
+		AuthorID:     appliedOp.AuthorId,
+		ClientID:     clientId,
+		ClientSeq:    clientSeq,
+		BaseRevision: baseRevision,
+		Ops:          appliedOp.Ops,
+		AppliedAt:    appliedOp.AppliedAt,
+	})
+	if err != nil {
+		return AppliedOp{}, err
+	}
+	if s.outboxOps != nil {
+		// 操作日志与事件同一事务写入
+		if err := s.outboxOps.AppendOpWithEvent(ctx, docID, appliedOp, evt, ds.leaseToken); err != nil {
+			return AppliedOp{}, s.checkFenced(docID, ds, err)
+		}
+	} else if s.opLog != nil {
+		if err := s.opLog.AppendOp(ctx, docID, appliedOp, ds.leaseToken); err != nil {
+			return AppliedOp{}, s.checkFenced(docID, ds, err)
+		}
+	}
+	if err := ds.buf.Apply(ops); err != nil {
+		return AppliedOp{}, err
+	}
 
-	// 保存到环形缓冲（如果达到容量则丢弃最老的一条）
-	if cap(ds.opsRing) > 0 && len(ds.opsRing) == cap(ds.opsRing) {
-		copy(ds.opsRing[0:], ds.opsRing[1:])
-		ds.opsRing = ds.opsRing[:len(ds.opsRing)-1]
+	// 推进版本
+	if ds.revision == ds.snapshotRev {
+		ds.dirtySince = appliedOp.AppliedAt
 	}
-	ds.opsRing = append(ds.opsRing, appliedOp)
+	ds.revision = appliedOp.Revision
+
+	ds.pushRing(appliedOp)
 
 	// 更新去重窗口
-	ds.lastSeqByClient[clientId] = clientSeq
-
-	// 异步发 Kafka（不阻塞主流程）
-	if s.kafkaDispatcher != nil && s.kafka != nil && s.kafkaTopic != "" {
-		evt := DocOpEvent{
-			EventType:    "OP_APPLIED",
-			DocID:        docID,
-			OperationID:  appliedOp.OperationId,
-			Revision:     appliedOp.Revision,
-			AuthorID:     appliedOp.AuthorId,
-			ClientID:     clientId,
-			ClientSeq:    clientSeq,
-			BaseRevision: baseRevision,
-			Ops:          appliedOp.Ops,
-			AppliedAt:    appliedOp.AppliedAt,
-		}
+	ds.rememberClientOp(appliedOp)
 
-		// 短等待把事件放入本地队列，后台重试发送 Kafka。
-		enqueueCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
-		defer cancel()
-		if err := s.kafkaDispatcher.Enqueue(enqueueCtx, evt); err != nil {
-			// 超时：降级丢弃，但不影响主流程
-			log.Printf("kafka queue busy, drop event doc=%s rev=%d: %v", docID, appliedOp.Revision, err)
-		}
+	// 事件由 emitter 按 revision 顺序发出；outbox 模式下事件已经随操作日志落库
+	out := docOutput{op: &appliedOp}
+	if s.outboxOps == nil {
+		out.event = &evt
 	}
+	ds.output(out)
 
 	return appliedOp, nil
 }
 
-// 返回当前文档版本
-func (s *InMemoryService) CurrentRevision(ctx context.Context, docID string) (uint64, error) {
-	s.mu.RLock()
-	ds := s.docs[docID]
-	s.mu.RUnlock()
-	if ds == nil {
-		return 0, nil
+// 交给 EventPublisher，失败只记日志，不影响主流程。BoundedPublisher 满时最多等待它给出的期限；
+// 其他出口使用调用方的 ctx，不因为短超时丢事件
+func (s *InMemoryService) emit(ctx context.Context, evt DocEvent) {
+	if bounded, ok := s.events.(BoundedPublisher); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, bounded.PublishTimeout())
+		defer cancel()
+	}
+	if err := s.events.Publish(ctx, evt); err != nil {
+		log.Printf("publish event failed, drop doc=%s type=%s id=%s: %v", evt.DocID, evt.EventType, evt.EventID, err)
 	}
-	ds.mu.RLock()
-	defer ds.mu.RUnlock()
-	return ds.revision, nil
 }
 
-// 返回 fromRevision 之后的已应用操作
-func (s *InMemoryService) OpsSince(ctx context.Context, docID string, fromRevision uint64, limit int) ([]AppliedOp, error) {
-	s.mu.RLock()
-	ds := s.docs[docID]
-	s.mu.RUnlock()
-	if ds == nil {
-		return nil, nil
+// 发出一个没有对应数据写入的事件（outbox 模式下 EventPublisher 就是 outbox 表）
+func (s *InMemoryService) publishEvent(ctx context.Context, eventType, docID string, payload any) {
+	evt, err := NewDocEvent(s.ids.Next().String(), eventType, docID, time.Now(), payload)
+	if err != nil {
+		log.Printf("build %s event doc=%s failed: %v", eventType, docID, err)
+		return
 	}
-	ds.mu.RLock()
-	defer ds.mu.RUnlock()
+	s.emit(ctx, evt)
+}
+
+func (s *InMemoryService) RecordMembership(ctx context.Context, docID string, userID uint64, username string, joined bool) {
+	eventType := EventMemberLeft
+	if joined {
+		eventType = EventMemberJoined
+	}
+	s.publishEvent(ctx, eventType, docID, MemberPayload{UserID: userID, Username: username})
+}
+
+// 返回当前文档版本
+func (s *InMemoryService) CurrentRevision(ctx context.Context, docID string) (uint64, error) {
+	var rev uint64
+	err := s.exec(ctx, docID, func(ds *docState) error {
+		rev = ds.revision
+		return nil
+	})
+	return rev, err
+}
 
+// 返回 fromRevision 之后的已应用操作。
+// 环形缓冲只保留最近 ringCap 条，fromRevision 更早时（或重启后缓冲为空）回退到操作日志分页读取。
+func (s *InMemoryService) OpsSince(ctx context.Context, docID string, fromRevision uint64, limit int) ([]AppliedOp, error) {
 	var out []AppliedOp
-	for _, op := range ds.opsRing {
-		if op.Revision > fromRevision {
-			out = append(out, op)
-			if limit > 0 && len(out) >= limit {
-				break
+	fromLog := false
+	err := s.exec(ctx, docID, func(ds *docState) error {
+		if fromRevision >= ds.revision {
+			return nil
+		}
+		if s.opLog != nil && (len(ds.opsRing) == 0 || ds.opsRing[0].Revision > fromRevision+1) {
+			fromLog = true
+			return nil
+		}
+		for _, op := range ds.opsRing {
+			if op.Revision > fromRevision {
+				out = append(out, op)
+				if limit > 0 && len(out) >= limit {
+					break
+				}
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fromLog {
+		// 读库不占用 sequencer，避免阻塞 Submit
+		return s.opLog.LoadOps(ctx, docID, fromRevision, limit)
 	}
 	return out, nil
 }
@@ -245,20 +654,101 @@ func (s *InMemoryService) SaveSnapshot(ctx context.Context, docID string) error
 	if s.store == nil {
 		return errors.New("snapshot store not initialized")
 	}
-	s.mu.RLock()
-	ds := s.docs[docID]
-	s.mu.RUnlock()
-	if ds == nil {
-		return errors.New("document not found")
+	ds, err := s.getOrCreateDoc(ctx, docID)
+	if err != nil {
+		return err
 	}
-	ds.mu.RLock()
-	defer ds.mu.RUnlock()
-	if ds.buf == nil {
-		return errors.New("buffer not initialized")
+	return s.saveDocSnapshot(ctx, docID, ds)
+}
+
+// 把 ds 当前内容写入快照存储并推进 snapshotRev（不更新访问时间，淘汰时也用它）。
+// 文档已经退出时不再保存：被淘汰前已经快照过，租约丢失时则不应该再写。
+func (s *InMemoryService) saveDocSnapshot(ctx context.Context, docID string, ds *docState) error {
+	var doc delta.Delta
+	var rev uint64
+	err := ds.do(ctx, func(ds *docState) error {
+		if ds.buf == nil {
+			return errors.New("buffer not initialized")
+		}
+		doc, rev = ds.buf.Delta(), ds.revision
+		return nil
+	})
+	if errors.Is(err, errDocRetired) {
+		return nil
 	}
-	content := ds.buf.String()
-	rev := ds.revision
-	return s.store.SaveDocumentSnapshot(ctx, docID, rev, content)
+	if err != nil {
+		return err
+	}
+
+	// 写库不占用 sequencer
+	evt, err := NewDocEvent(s.ids.Next().String(), EventSnapshotSaved, docID, time.Now(), SnapshotSavedPayload{Revision: rev})
+	if err != nil {
+		return err
+	}
+	// outbox 模式下事件随快照落库，id 可能排在之后的 OP_APPLIED 后面（见 WithOutbox）
+	if s.outboxSnapshots != nil {
+		if err := s.outboxSnapshots.SaveDocumentSnapshotWithEvent(ctx, docID, rev, doc, evt, ds.leaseToken); err != nil {
+			return s.checkFenced(docID, ds, err)
+		}
+	} else if err := s.store.SaveDocumentSnapshot(ctx, docID, rev, doc, ds.leaseToken); err != nil {
+		return s.checkFenced(docID, ds, err)
+	}
+
+	queued := false
+	err = ds.do(ctx, func(ds *docState) error {
+		if rev > ds.snapshotRev {
+			ds.snapshotRev = rev
+			if ds.revision == rev {
+				ds.dirtySince = time.Time{}
+			} else {
+				// 写库期间又有新的修改
+				ds.dirtySince = time.Now()
+			}
+		}
+		// 排在 rev 及之前操作的事件之后发出
+		if s.outboxSnapshots == nil {
+			ds.output(docOutput{event: &evt})
+			queued = true
+		}
+		return nil
+	})
+	if s.outboxSnapshots == nil && !queued {
+		s.emit(context.WithoutCancel(ctx), evt)
+	}
+	if errors.Is(err, errDocRetired) {
+		return nil
+	}
+	return err
+}
+
+// 单个文档的快照滞后情况
+type SnapshotLag struct {
+	DocID            string        `json:"docId"`
+	Revision         uint64        `json:"revision"`
+	SnapshotRevision uint64        `json:"snapshotRevision"`
+	PendingOps       uint64        `json:"pendingOps"` // 尚未进入快照的操作数
+	DirtyFor         time.Duration `json:"dirtyFor"`   // 自快照后第一次修改起经过的时间
+}
+
+func (s *InMemoryService) SnapshotLags() []SnapshotLag {
+	docs := s.liveDocs()
+	now := time.Now()
+	out := make([]SnapshotLag, 0, len(docs))
+	for id, ds := range docs {
+		var lag SnapshotLag
+		// 跳过仍在加载中或一时排不上队的文档，下一轮再看
+		ok := ds.peek(func(ds *docState) {
+			lag = SnapshotLag{DocID: id, Revision: ds.revision, SnapshotRevision: ds.snapshotRev}
+			if ds.revision > ds.snapshotRev {
+				lag.PendingOps = ds.revision - ds.snapshotRev
+				lag.DirtyFor = now.Sub(ds.dirtySince)
+			}
+		})
+		if ok {
+			out = append(out, lag)
+		}
+	}
+	return out
 }
 
 func (s *InMemoryService) GetDocumentID(ctx context.Context, title string) (string, error) {
@@ -268,9 +758,14 @@ func (s *InMemoryService) GetDocumentID(ctx context.Context, title string) (stri
 	return s.documentStore.GetDocumentID(ctx, title)
 }
 
-func (s *InMemoryService) CreateDocument(ctx context.Context, ownerID uint64, title string) error {
+func (s *InMemoryService) CreateDocument(ctx context.Context, ownerID uint64, title string) (string, error) {
 	if s.documentStore == nil {
-		return errors.New("document store not initialized")
+		return "", errors.New("document store not initialized")
+	}
+	docID := s.ids.Next().String()
+	if err := s.documentStore.CreateDocument(ctx, docID, ownerID, title); err != nil {
+		return "", err
 	}
-	return s.documentStore.CreateDocument(ctx, ownerID, title)
+	s.publishEvent(ctx, EventDocumentCreated, docID, DocumentCreatedPayload{OwnerID: ownerID, Title: title})
+	return docID, nil
 }
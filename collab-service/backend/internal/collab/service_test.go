@@ -0,0 +1,331 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+func TestInMemoryService_SubmitRebasesStaleOps(t *testing.T) {
+	svc := NewInMemoryService(nil, nil, nil, nil)
+	ctx := context.Background()
+
+	init := delta.Delta{{Kind: delta.KindInsert, Text: "Hello world"}}
+	if _, err := svc.Submit(ctx, "doc", 1, 0, "c1", 1, init); err != nil {
+		t.Fatalf("Submit(init) error = %v", err)
+	}
+
+	// 两个客户端都基于 revision 1 并发编辑
+	a := delta.Delta{{Kind: delta.KindRetain, Count: 5}, {Kind: delta.KindInsert, Text: ","}}
+	b := delta.Delta{{Kind: delta.KindRetain, Count: 11}, {Kind: delta.KindInsert, Text: "!"}}
+	if _, err := svc.Submit(ctx, "doc", 1, 1, "c1", 2, a); err != nil {
+		t.Fatalf("Submit(a) error = %v", err)
+	}
+	applied, err := svc.Submit(ctx, "doc", 2, 1, "c2", 1, b)
+	if err != nil {
+		t.Fatalf("Submit(b) error = %v", err)
+	}
+	if applied.Revision != 3 {
+		t.Fatalf("Revision = %d, want 3", applied.Revision)
+	}
+	if applied.Ops[0].Count != 12 {
+		t.Fatalf("transformed retain = %d, want 12", applied.Ops[0].Count)
+	}
+
+	content, _, err := svc.LoadDocumentContent(ctx, "doc")
+	if err != nil {
+		t.Fatalf("LoadDocumentContent() error = %v", err)
+	}
+	if want := "Hello, world!"; content != want {
+		t.Fatalf("content = %q, want %q", content, want)
+	}
+}
+
+func TestInMemoryService_SubmitFutureRevisionConflicts(t *testing.T) {
+	svc := NewInMemoryService(nil, nil, nil, nil)
+	ops := delta.Delta{{Kind: delta.KindInsert, Text: "x"}}
+	if _, err := svc.Submit(context.Background(), "doc", 1, 5, "c1", 1, ops); !errors.Is(err, ErrRevisionConflict) {
+		t.Fatalf("Submit() error = %v, want %v", err, ErrRevisionConflict)
+	}
+}
+
+func TestInMemoryService_SubmitTooStaleBaseIsRejected(t *testing.T) {
+	svc := NewInMemoryService(nil, &fakeOpLog{}, nil, nil, WithMaxRebaseOps(2))
+	ctx := context.Background()
+	for seq := uint64(1); seq <= 3; seq++ {
+		if _, err := svc.Submit(ctx, "doc", 1, seq-1, "c1", seq, delta.Delta{{Kind: delta.KindInsert, Text: "x"}}); err != nil {
+			t.Fatalf("Submit(%d) error = %v", seq, err)
+		}
+	}
+
+	// 落后 3 个版本超过上限，客户端需要重新同步；落后 2 个仍然正常变换
+	ops := delta.Delta{{Kind: delta.KindInsert, Text: "y"}}
+	if _, err := svc.Submit(ctx, "doc", 2, 0, "c2", 1, ops); !errors.Is(err, ErrBaseTooOld) {
+		t.Fatalf("Submit(base 0) error = %v, want %v", err, ErrBaseTooOld)
+	}
+	applied, err := svc.Submit(ctx, "doc", 2, 1, "c2", 1, ops)
+	if err != nil || applied.Revision != 4 {
+		t.Fatalf("Submit(base 1) = %+v, %v; want revision 4", applied, err)
+	}
+}
+
+// 内存版快照存储，记录加载次数
+type fakeSnapshotStore struct {
+	mu        sync.Mutex
+	snapshots map[string][]fakeSnapshot
+	loads     int
+}
+
+type fakeSnapshot struct {
+	rev uint64
+	doc delta.Delta
+}
+
+// 纯文本文档
+func textDoc(s string) delta.Delta {
+	return delta.Delta{{Kind: delta.KindInsert, Text: s}}
+}
+
+func (f *fakeSnapshotStore) SaveDocumentSnapshot(ctx context.Context, docID string, rev uint64, doc delta.Delta, fence uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.snapshots == nil {
+		f.snapshots = make(map[string][]fakeSnapshot)
+	}
+	f.snapshots[docID] = append(f.snapshots[docID], fakeSnapshot{rev: rev, doc: doc})
+	return nil
+}
+
+func (f *fakeSnapshotStore) LoadLatestSnapshot(ctx context.Context, docID string) (delta.Delta, uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loads++
+	list := f.snapshots[docID]
+	if len(list) == 0 {
+		return nil, 0, nil
+	}
+	last := list[len(list)-1]
+	return last.doc, last.rev, nil
+}
+
+func (f *fakeSnapshotStore) ListSnapshots(ctx context.Context, docID string, limit int) ([]SnapshotInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []SnapshotInfo
+	list := f.snapshots[docID]
+	for i := len(list) - 1; i >= 0; i-- {
+		out = append(out, SnapshotInfo{Revision: list[i].rev, Size: len([]rune(list[i].doc.Text()))})
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeSnapshotStore) LoadSnapshot(ctx context.Context, docID string, rev uint64) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, snap := range f.snapshots[docID] {
+		if snap.rev == rev {
+			return snap.doc.Text(), nil
+		}
+	}
+	return "", ErrSnapshotNotFound
+}
+
+func (f *fakeSnapshotStore) LoadSnapshotAtOrBefore(ctx context.Context, docID string, rev uint64) (delta.Delta, uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var best *fakeSnapshot
+	for i, snap := range f.snapshots[docID] {
+		if snap.rev <= rev && (best == nil || snap.rev > best.rev) {
+			best = &f.snapshots[docID][i]
+		}
+	}
+	if best == nil {
+		return nil, 0, nil
+	}
+	return best.doc, best.rev, nil
+}
+
+func TestInMemoryService_HydratesFromLatestSnapshotOnce(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	_ = store.SaveDocumentSnapshot(context.Background(), "doc", 3, textDoc("old"), 0)
+	_ = store.SaveDocumentSnapshot(context.Background(), "doc", 7, textDoc("Hello world"), 0)
+	store.loads = 0
+	svc := NewInMemoryService(store, nil, nil, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			content, rev, err := svc.LoadDocumentContent(context.Background(), "doc")
+			if err != nil || content != "Hello world" || rev != 7 {
+				t.Errorf("LoadDocumentContent() = %q, %d, %v; want %q, 7, nil", content, rev, err, "Hello world")
+			}
+		}()
+	}
+	wg.Wait()
+	if store.loads != 1 {
+		t.Fatalf("LoadLatestSnapshot called %d times, want 1", store.loads)
+	}
+
+	// 基于快照版本继续编辑
+	ops := delta.Delta{{Kind: delta.KindRetain, Count: 11}, {Kind: delta.KindInsert, Text: "!"}}
+	applied, err := svc.Submit(context.Background(), "doc", 1, 7, "c1", 1, ops)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if applied.Revision != 8 {
+		t.Fatalf("Revision = %d, want 8", applied.Revision)
+	}
+}
+
+// 内存版操作日志
+type fakeOpLog struct {
+	mu     sync.Mutex
+	ops    map[string][]AppliedOp
+	fences map[string]uint64
+}
+
+func (f *fakeOpLog) AppendOp(ctx context.Context, docID string, op AppliedOp, fence uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.ops == nil {
+		f.ops = make(map[string][]AppliedOp)
+		f.fences = make(map[string]uint64)
+	}
+	if fence < f.fences[docID] {
+		return ErrFenced
+	}
+	f.fences[docID] = fence
+	f.ops[docID] = append(f.ops[docID], op)
+	return nil
+}
+
+func (f *fakeOpLog) LoadOps(ctx context.Context, docID string, fromRevision uint64, limit int) ([]AppliedOp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []AppliedOp
+	for _, op := range f.ops[docID] {
+		if op.Revision > fromRevision {
+			out = append(out, op)
+			if limit > 0 && len(out) >= limit {
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func TestInMemoryService_OpsSinceFallsBackToOpLog(t *testing.T) {
+	opLog := &fakeOpLog{}
+	svc := NewInMemoryService(nil, opLog, nil, nil).(*InMemoryService)
+	svc.ringCap = 2
+	ctx := context.Background()
+
+	for i := uint64(0); i < 5; i++ {
+		ops := delta.Delta{{Kind: delta.KindInsert, Text: "x"}}
+		if _, err := svc.Submit(ctx, "doc", 1, i, "c1", i+1, ops); err != nil {
+			t.Fatalf("Submit(%d) error = %v", i, err)
+		}
+	}
+
+	// 环形缓冲只剩 rev 4、5，更早的需要从操作日志读取
+	ops, err := svc.OpsSince(ctx, "doc", 1, 2)
+	if err != nil {
+		t.Fatalf("OpsSince() error = %v", err)
+	}
+	if len(ops) != 2 || ops[0].Revision != 2 || ops[1].Revision != 3 {
+		t.Fatalf("OpsSince(1, limit=2) = %+v, want revisions [2 3]", ops)
+	}
+
+	ops, err = svc.OpsSince(ctx, "doc", 3, 0)
+	if err != nil {
+		t.Fatalf("OpsSince() error = %v", err)
+	}
+	if len(ops) != 2 || ops[0].Revision != 4 {
+		t.Fatalf("OpsSince(3) = %+v, want revisions [4 5]", ops)
+	}
+}
+
+func TestInMemoryService_SubmitDuplicateReturnsOriginalOp(t *testing.T) {
+	svc := NewInMemoryService(nil, nil, nil, nil)
+	ctx := context.Background()
+
+	first, err := svc.Submit(ctx, "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "a"}})
+	if err != nil {
+		t.Fatalf("Submit(seq 1) error = %v", err)
+	}
+	if _, err := svc.Submit(ctx, "doc", 2, 1, "c2", 1, delta.Delta{{Kind: delta.KindInsert, Text: "b"}}); err != nil {
+		t.Fatalf("Submit(c2) error = %v", err)
+	}
+
+	// 重试 seq 1：返回原结果，文档不变
+	again, err := svc.Submit(ctx, "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "a"}})
+	var dup *DuplicateOpError
+	if !errors.As(err, &dup) || again.OperationId != first.OperationId || again.Revision != 1 {
+		t.Fatalf("Submit(dup) = %+v, %v; want original op rev 1 and DuplicateOpError", again, err)
+	}
+	if content, rev, _ := svc.LoadDocumentContent(ctx, "doc"); content != "ba" || rev != 2 {
+		t.Fatalf("content = %q rev %d, want %q rev 2", content, rev, "ba")
+	}
+
+	// 跳过 seq 2：要求从 2 重发
+	_, err = svc.Submit(ctx, "doc", 1, 2, "c1", 3, delta.Delta{{Kind: delta.KindInsert, Text: "c"}})
+	var ooo *OutOfOrderError
+	if !errors.As(err, &ooo) || ooo.ExpectedSeq != 2 {
+		t.Fatalf("Submit(seq 3) error = %v, want OutOfOrderError expecting 2", err)
+	}
+}
+
+func TestInMemoryService_SnapshotKeepsFormattingAcrossReload(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	ctx := context.Background()
+	first := NewInMemoryService(store, nil, nil, nil)
+	ops := delta.Delta{
+		{Kind: delta.KindInsert, Text: "Hi", Attrs: map[string]any{"bold": true}},
+		{Kind: delta.KindInsert, Text: " there"},
+	}
+	if _, err := first.Submit(ctx, "doc", 1, 0, "c1", 1, ops); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if err := first.SaveSnapshot(ctx, "doc"); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+
+	// 另一个实例（重启 / 淘汰后重新加载）从快照恢复，样式不丢失
+	second := NewInMemoryService(store, nil, nil, nil)
+	doc, rev, err := second.LoadDocumentDelta(ctx, "doc")
+	if err != nil || rev != 1 {
+		t.Fatalf("LoadDocumentDelta() rev = %d, %v; want 1", rev, err)
+	}
+	if len(doc) != 2 || doc[0].Text != "Hi" || doc[0].Attrs["bold"] != true || doc[1].Attrs != nil {
+		t.Fatalf("reloaded doc = %+v, want bold %q followed by plain text", doc, "Hi")
+	}
+}
+
+func TestInMemoryService_ReloadRebuildsDedupWindow(t *testing.T) {
+	opLog := &fakeOpLog{}
+	ctx := context.Background()
+	first := NewInMemoryService(nil, opLog, nil, nil)
+	applied, err := first.Submit(ctx, "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "a"}})
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	// 重启 / 接管后从操作日志重放：ack 丢失后的重试仍被识别为重复提交
+	second := NewInMemoryService(nil, opLog, nil, nil)
+	again, err := second.Submit(ctx, "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "a"}})
+	var dup *DuplicateOpError
+	if !errors.As(err, &dup) || again.OperationId != applied.OperationId {
+		t.Fatalf("Submit(retry) = %+v, %v; want original op and DuplicateOpError", again, err)
+	}
+	if content, rev, _ := second.LoadDocumentContent(ctx, "doc"); content != "a" || rev != 1 {
+		t.Fatalf("content = %q rev %d, want %q rev 1", content, rev, "a")
+	}
+}
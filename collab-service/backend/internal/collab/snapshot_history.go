@@ -0,0 +1,160 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+func (s *InMemoryService) ListSnapshots(ctx context.Context, docID string, limit int) ([]SnapshotInfo, error) {
+	if s.store == nil {
+		return nil, errors.New("snapshot store not initialized")
+	}
+	return s.store.ListSnapshots(ctx, docID, limit)
+}
+
+func (s *InMemoryService) GetSnapshot(ctx context.Context, docID string, rev uint64) (string, error) {
+	if s.store == nil {
+		return "", errors.New("snapshot store not initialized")
+	}
+	return s.store.LoadSnapshot(ctx, docID, rev)
+}
+
+// RestoreSnapshot 不会回退版本号：它计算「当前文档 -> 快照文档」的 delta（文字和样式都恢复），
+// 作为一个新版本应用，协作者像收到普通编辑一样收到这次回滚。
+func (s *InMemoryService) RestoreSnapshot(ctx context.Context, docID string, authorID uint64, rev uint64) (AppliedOp, error) {
+	if s.store == nil {
+		return AppliedOp{}, errors.New("snapshot store not initialized")
+	}
+	target, snapRev, err := s.store.LoadSnapshotAtOrBefore(ctx, docID, rev)
+	if err != nil {
+		return AppliedOp{}, err
+	}
+	// 没有任何快照时返回 (nil, 0)，不能当成版本 0 的空快照
+	if snapRev != rev || target == nil && rev == 0 {
+		return AppliedOp{}, ErrSnapshotNotFound
+	}
+	var applied AppliedOp
+	err = s.exec(ctx, docID, func(ds *docState) error {
+		ops := diffDoc(ds.buf.Delta(), target)
+		var err error
+		if applied, err = s.applyOp(ctx, docID, ds, authorID, ds.revision, "", 0, ops); err != nil {
+			return err
+		}
+		// 紧跟在这次操作的 OP_APPLIED 之后发出
+		evt, err := NewDocEvent(s.ids.Next().String(), EventSnapshotRestored, docID, time.Now(), SnapshotRestoredPayload{
+			RestoredRevision: rev,
+			Revision:         applied.Revision,
+			OperationID:      applied.OperationId,
+			AuthorID:         authorID,
+		})
+		if err != nil {
+			log.Printf("build %s event doc=%s failed: %v", EventSnapshotRestored, docID, err)
+			return nil
+		}
+		ds.output(docOutput{event: &evt})
+		return nil
+	})
+	if err != nil {
+		return AppliedOp{}, err
+	}
+	return applied, nil
+}
+
+// 重放历史时每次从操作日志读取的条数
+const replayPageSize = 500
+
+// ContentAtRevision 找到 rev 及之前最近的快照，在新的 PieceTree 上依次重放操作日志里之后的操作，
+// 得到文档在 rev 时带样式的内容。只读快照存储和操作日志，不加载内存文档也不需要租约，任何实例都能查询。
+func (s *InMemoryService) ContentAtRevision(ctx context.Context, docID string, rev uint64) (delta.Delta, error) {
+	if s.store == nil {
+		return nil, errors.New("snapshot store not initialized")
+	}
+	if s.opLog == nil {
+		return nil, errors.New("op log not initialized")
+	}
+
+	doc, cur, err := s.store.LoadSnapshotAtOrBefore(ctx, docID, rev)
+	if err != nil {
+		return nil, err
+	}
+	pt := NewPieceTreeFromDelta(doc)
+	for cur < rev {
+		ops, err := s.opLog.LoadOps(ctx, docID, cur, min(replayPageSize, int(rev-cur)))
+		if err != nil {
+			return nil, err
+		}
+		if len(ops) == 0 {
+			// 操作日志到头了：rev 比最新版本还新
+			return nil, fmt.Errorf("%w: %d > latest %d", ErrRevisionNotFound, rev, cur)
+		}
+		for _, op := range ops {
+			if op.Revision != cur+1 {
+				return nil, fmt.Errorf("%w: expected revision %d, got %d", ErrHistoryUnavailable, cur+1, op.Revision)
+			}
+			if err := pt.Apply(op.Ops); err != nil {
+				return nil, fmt.Errorf("replay revision %d: %w", op.Revision, err)
+			}
+			cur = op.Revision
+		}
+	}
+	return pt.Delta(), nil
+}
+
+// 计算把文档 from 变成文档 to 的 delta（两者都只含 insert）：文字和样式都相同的公共前缀、后缀保留，
+// 中间部分整体删除，再按 to 原样（带样式）插入
+func diffDoc(from, to delta.Delta) delta.Delta {
+	a, b := docChars(from), docChars(to)
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix].equal(b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix].equal(b[len(b)-1-suffix]) {
+		suffix++
+	}
+
+	var d delta.Delta
+	if prefix > 0 {
+		d = append(d, delta.Op{Kind: delta.KindRetain, Count: prefix})
+	}
+	if n := len(a) - prefix - suffix; n > 0 {
+		d = append(d, delta.Op{Kind: delta.KindDelete, Count: n})
+	}
+	for _, c := range b[prefix : len(b)-suffix] {
+		d = append(d, delta.Op{Kind: delta.KindInsert, Text: string(c.r), Attrs: c.attrs})
+	}
+	// 相邻同样式的单字符 insert 合并回整段
+	return delta.Normalize(d)
+}
+
+// 文档中的一个字符及其样式
+type docChar struct {
+	r     rune
+	attrs map[string]any
+}
+
+func (c docChar) equal(o docChar) bool {
+	if c.r != o.r {
+		return false
+	}
+	if len(c.attrs) == 0 && len(o.attrs) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(c.attrs, o.attrs)
+}
+
+func docChars(doc delta.Delta) []docChar {
+	var out []docChar
+	for _, op := range doc {
+		for _, r := range op.Text {
+			out = append(out, docChar{r: r, attrs: op.Attrs})
+		}
+	}
+	return out
+}
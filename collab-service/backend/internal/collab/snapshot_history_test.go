@@ -0,0 +1,162 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+func TestInMemoryService_RestoreSnapshot(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	svc := NewInMemoryService(store, nil, nil, nil)
+	ctx := context.Background()
+
+	if _, err := svc.Submit(ctx, "doc", 1, 0, "c1", 1, delta.Delta{{Kind: delta.KindInsert, Text: "Hello world"}}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if err := svc.SaveSnapshot(ctx, "doc"); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	edit := delta.Delta{{Kind: delta.KindRetain, Count: 6}, {Kind: delta.KindDelete, Count: 5}, {Kind: delta.KindInsert, Text: "there, friend"}}
+	if _, err := svc.Submit(ctx, "doc", 1, 1, "c1", 2, edit); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	snaps, err := svc.ListSnapshots(ctx, "doc", 10)
+	if err != nil || len(snaps) != 1 || snaps[0].Revision != 1 || snaps[0].Size != 11 {
+		t.Fatalf("ListSnapshots() = %+v, %v; want one snapshot at rev 1 size 11", snaps, err)
+	}
+
+	applied, err := svc.RestoreSnapshot(ctx, "doc", 2, 1)
+	if err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	if applied.Revision != 3 {
+		t.Fatalf("restore Revision = %d, want 3", applied.Revision)
+	}
+	content, rev, _ := svc.LoadDocumentContent(ctx, "doc")
+	if content != "Hello world" || rev != 3 {
+		t.Fatalf("after restore content = %q rev = %d, want %q rev 3", content, rev, "Hello world")
+	}
+
+	if _, err := svc.RestoreSnapshot(ctx, "doc", 2, 42); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("RestoreSnapshot(missing) error = %v, want %v", err, ErrSnapshotNotFound)
+	}
+}
+
+func TestInMemoryService_RestoreFormattedSnapshot(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	svc := NewInMemoryService(store, nil, nil, nil)
+	ctx := context.Background()
+
+	bold := map[string]any{"bold": true}
+	original := delta.Delta{{Kind: delta.KindInsert, Text: "Hello", Attrs: bold}, {Kind: delta.KindInsert, Text: " world"}}
+	if _, err := svc.Submit(ctx, "doc", 1, 0, "c1", 1, original); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if err := svc.SaveSnapshot(ctx, "doc"); err != nil {
+		t.Fatalf("SaveSnapshot() error = %v", err)
+	}
+	// 去掉加粗、给 world 加斜体，文字不变
+	edit := delta.Delta{{Kind: delta.KindRetain, Count: 5, Attrs: map[string]any{"bold": nil}},
+		{Kind: delta.KindRetain, Count: 1}, {Kind: delta.KindRetain, Count: 5, Attrs: map[string]any{"italic": true}}}
+	if _, err := svc.Submit(ctx, "doc", 1, 1, "c1", 2, edit); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	if _, err := svc.RestoreSnapshot(ctx, "doc", 2, 1); err != nil {
+		t.Fatalf("RestoreSnapshot() error = %v", err)
+	}
+	doc, rev, _ := svc.LoadDocumentDelta(ctx, "doc")
+	if rev != 3 || !reflect.DeepEqual(doc, delta.Normalize(original)) {
+		t.Fatalf("after restore doc = %+v rev = %d, want %+v rev 3", doc, rev, original)
+	}
+}
+
+func TestDiffDoc(t *testing.T) {
+	bold := map[string]any{"bold": true}
+	cases := []struct{ from, to delta.Delta }{
+		{textDoc("Hello world"), textDoc("Hello there")},
+		{nil, textDoc("abc")},
+		{textDoc("abc"), nil},
+		{textDoc("aaa"), textDoc("aaaa")},
+		{textDoc("协作文档"), textDoc("协同文档")},
+		{textDoc("abc"), delta.Delta{{Kind: delta.KindInsert, Text: "a"}, {Kind: delta.KindInsert, Text: "b", Attrs: bold}, {Kind: delta.KindInsert, Text: "c"}}},
+		{delta.Delta{{Kind: delta.KindInsert, Text: "abc", Attrs: bold}}, textDoc("abc")},
+	}
+	for _, tc := range cases {
+		pt := NewPieceTreeFromDelta(tc.from)
+		if err := pt.Apply(diffDoc(tc.from, tc.to)); err != nil {
+			t.Fatalf("Apply(diffDoc(%+v, %+v)) error = %v", tc.from, tc.to, err)
+		}
+		if got := pt.Delta(); !reflect.DeepEqual(got, delta.Normalize(tc.to)) {
+			t.Fatalf("diffDoc(%+v, %+v) produced %+v", tc.from, tc.to, got)
+		}
+	}
+}
+
+func TestInMemoryService_ContentAtRevision(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	opLog := &fakeOpLog{}
+	svc := NewInMemoryService(store, opLog, nil, nil)
+	ctx := context.Background()
+
+	texts := []string{"a", "b", "c", "d", "e"}
+	for i, text := range texts {
+		ops := delta.Delta{{Kind: delta.KindRetain, Count: i}, {Kind: delta.KindInsert, Text: text}}
+		if i == 0 {
+			ops = ops[1:]
+		}
+		if _, err := svc.Submit(ctx, "doc", 1, uint64(i), "c1", uint64(i+1), ops); err != nil {
+			t.Fatalf("Submit(%d) error = %v", i, err)
+		}
+		if i == 1 {
+			if err := svc.SaveSnapshot(ctx, "doc"); err != nil {
+				t.Fatalf("SaveSnapshot() error = %v", err)
+			}
+		}
+	}
+
+	for rev, want := range []string{"", "a", "ab", "abc", "abcd", "abcde"} {
+		doc, err := svc.ContentAtRevision(ctx, "doc", uint64(rev))
+		if err != nil {
+			t.Fatalf("ContentAtRevision(%d) error = %v", rev, err)
+		}
+		if got := doc.Text(); got != want {
+			t.Fatalf("ContentAtRevision(%d) = %q, want %q", rev, got, want)
+		}
+	}
+	if _, err := svc.ContentAtRevision(ctx, "doc", 6); !errors.Is(err, ErrRevisionNotFound) {
+		t.Fatalf("ContentAtRevision(6) error = %v, want %v", err, ErrRevisionNotFound)
+	}
+}
+
+func TestInMemoryService_ContentAtRevisionOnNonOwner(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	opLog := &fakeOpLog{}
+	leases := newFakeLeases()
+	owner := NewInMemoryService(store, opLog, nil, nil, WithOwnership(&fakeOwnership{leases: leases, instance: "a:3002"}))
+	other := NewInMemoryService(store, opLog, nil, nil, WithOwnership(&fakeOwnership{leases: leases, instance: "b:3002"}))
+	ctx := context.Background()
+
+	bold := map[string]any{"bold": true}
+	if _, err := owner.Submit(ctx, "doc", 1, 0, "c1", 1, textDoc("Hello")); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if _, err := owner.Submit(ctx, "doc", 1, 1, "c1", 2, delta.Delta{{Kind: delta.KindRetain, Count: 5, Attrs: bold}}); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	// 非 owner 只读存储，不会因为不持有租约而失败，拿到的是带样式的内容
+	doc, err := other.ContentAtRevision(ctx, "doc", 2)
+	if err != nil {
+		t.Fatalf("ContentAtRevision() on non-owner error = %v", err)
+	}
+	want := delta.Delta{{Kind: delta.KindInsert, Text: "Hello", Attrs: bold}}
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("ContentAtRevision() = %+v, want %+v", doc, want)
+	}
+}
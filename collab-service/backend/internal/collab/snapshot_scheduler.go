@@ -0,0 +1,127 @@
+package collab
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// SnapshotScheduler：后台定期给「脏」文档打快照。
+// 触发条件：
+// - 距上次快照累计 EveryOps 个操作
+// - 快照后第一次修改起超过 Interval
+// - 房间最后一个成员离开（Notify）
+// - 停止时（Stop）把所有脏文档刷一遍
+type SnapshotScheduler struct {
+	svc Service
+
+	everyOps      uint64
+	interval      time.Duration
+	checkInterval time.Duration
+	saveTimeout   time.Duration
+
+	trigger chan string
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type SnapshotSchedulerOptions struct {
+	EveryOps      uint64
+	Interval      time.Duration
+	CheckInterval time.Duration // 检查周期，默认 1s
+	SaveTimeout   time.Duration // 单次快照写库超时，默认 5s
+}
+
+func NewSnapshotScheduler(svc Service, opt SnapshotSchedulerOptions) *SnapshotScheduler {
+	if opt.CheckInterval <= 0 {
+		opt.CheckInterval = time.Second
+	}
+	if opt.SaveTimeout <= 0 {
+		opt.SaveTimeout = 5 * time.Second
+	}
+	s := &SnapshotScheduler{
+		svc:           svc,
+		everyOps:      opt.EveryOps,
+		interval:      opt.Interval,
+		checkInterval: opt.CheckInterval,
+		saveTimeout:   opt.SaveTimeout,
+		trigger:       make(chan string, 64),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go s.loop()
+	return s
+}
+
+// Notify 请求尽快给 docID 打快照（例如房间已空）。
+// 不阻塞调用方：通道满时直接放弃，交给周期检查兜底。
+func (s *SnapshotScheduler) Notify(docID string) {
+	select {
+	case s.trigger <- docID:
+	default:
+	}
+}
+
+// Stop 停止后台循环，并在 ctx 截止前把所有脏文档快照一次
+func (s *SnapshotScheduler) Stop(ctx context.Context) error {
+	close(s.stop)
+	<-s.done
+	return s.Flush(ctx)
+}
+
+// Flush 给所有存在未快照操作的文档打快照，返回遇到的所有错误
+func (s *SnapshotScheduler) Flush(ctx context.Context) error {
+	var errs []error
+	for _, lag := range s.svc.SnapshotLags() {
+		if lag.PendingOps == 0 {
+			continue
+		}
+		if err := s.svc.SaveSnapshot(ctx, lag.DocID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *SnapshotScheduler) loop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, lag := range s.svc.SnapshotLags() {
+				if s.due(lag) {
+					s.save(lag)
+				}
+			}
+		case docID := <-s.trigger:
+			for _, lag := range s.svc.SnapshotLags() {
+				if lag.DocID == docID && lag.PendingOps > 0 {
+					s.save(lag)
+				}
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *SnapshotScheduler) due(lag SnapshotLag) bool {
+	if lag.PendingOps == 0 {
+		return false
+	}
+	if s.everyOps > 0 && lag.PendingOps >= s.everyOps {
+		return true
+	}
+	return s.interval > 0 && lag.DirtyFor >= s.interval
+}
+
+func (s *SnapshotScheduler) save(lag SnapshotLag) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.saveTimeout)
+	defer cancel()
+	if err := s.svc.SaveSnapshot(ctx, lag.DocID); err != nil {
+		log.Printf("auto snapshot failed doc=%s rev=%d pending=%d: %v", lag.DocID, lag.Revision, lag.PendingOps, err)
+	}
+}
@@ -0,0 +1,51 @@
+package collab
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"collabServer/backend/internal/ot/delta"
+)
+
+func TestSnapshotScheduler_EveryOpsAndStop(t *testing.T) {
+	store := &fakeSnapshotStore{}
+	svc := NewInMemoryService(store, nil, nil, nil)
+	sched := NewSnapshotScheduler(svc, SnapshotSchedulerOptions{EveryOps: 2, CheckInterval: 5 * time.Millisecond})
+	ctx := context.Background()
+
+	insert := delta.Delta{{Kind: delta.KindInsert, Text: "x"}}
+	for i := uint64(0); i < 2; i++ {
+		if _, err := svc.Submit(ctx, "doc", 1, i, "c1", i+1, insert); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		doc, rev, _ := store.LoadLatestSnapshot(ctx, "doc")
+		if rev == 2 && doc.Text() == "xx" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no snapshot at rev 2 after EveryOps reached")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// 低于阈值的修改在 Stop 时被刷盘
+	if _, err := svc.Submit(ctx, "doc", 1, 2, "c1", 3, insert); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if err := sched.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if _, rev, _ := store.LoadLatestSnapshot(ctx, "doc"); rev != 3 {
+		t.Fatalf("snapshot rev after Stop = %d, want 3", rev)
+	}
+	for _, lag := range svc.SnapshotLags() {
+		if lag.PendingOps != 0 {
+			t.Fatalf("SnapshotLags() = %+v, want no pending ops", lag)
+		}
+	}
+}
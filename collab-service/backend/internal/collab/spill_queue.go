@@ -0,0 +1,272 @@
+package collab
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrSpillFull   = errors.New("SPILL_FULL")
+	ErrSpillClosed = errors.New("SPILL_CLOSED")
+)
+
+// SpillQueue：KafkaDispatcher 的磁盘溢出队列。
+// - 目录下若干只追加的段文件（<seq>.seg），每行一个 JSON 编码的 DocEvent
+// - 写满 segmentBytes 后切换到新段；所有段总大小超过 maxBytes 时拒绝写入（计入 Dropped）
+// - 回放按段序号、段内按行顺序进行，一个段全部发送成功后删除该段
+// - 进程重启后目录中残留的段会被重新回放；崩溃时可能重复发送（消费方按 eventId 去重）
+type SpillQueue struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu       sync.Mutex
+	segments []spillSegment // 按 seq 升序，最后一个可能是正在写入的段
+	w        *os.File       // 正在写入的段，nil 表示需要新开一个
+	wSeq     uint64
+	total    int64
+	nextSeq  uint64
+	// 最老的段已经回放到的位置（只在内存中记录，重启后从段头重新回放）
+	replayOffset int64
+	// Close 之后拒绝写入，避免停机时仍在运行的 worker 再打开新段
+	closed bool
+
+	spilled  atomic.Uint64
+	replayed atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+type spillSegment struct {
+	seq  uint64
+	size int64
+}
+
+// 溢出队列统计
+type SpillStats struct {
+	Spilled  uint64 `json:"spilled"`
+	Replayed uint64 `json:"replayed"`
+	Dropped  uint64 `json:"dropped"` // 超过总大小上限被拒绝的事件
+	Bytes    int64  `json:"bytes"`
+	Segments int    `json:"segments"`
+}
+
+func OpenSpillQueue(dir string, maxBytes, segmentBytes int64) (*SpillQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if segmentBytes <= 0 {
+		segmentBytes = 8 << 20
+	}
+	q := &SpillQueue{dir: dir, maxBytes: maxBytes, segmentBytes: segmentBytes}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".seg") {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		q.segments = append(q.segments, spillSegment{seq: seq, size: info.Size()})
+		q.total += info.Size()
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].seq < q.segments[j].seq })
+	if n := len(q.segments); n > 0 {
+		q.nextSeq = q.segments[n-1].seq + 1
+		log.Printf("spill queue: found %d segments (%d bytes) to replay in %s", n, q.total, dir)
+	}
+	return q, nil
+}
+
+func (q *SpillQueue) segmentPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.seg", seq))
+}
+
+// HasBacklog 是否还有未回放的事件。有积压时新事件也应写入溢出队列，保持顺序。
+func (q *SpillQueue) HasBacklog() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.segments) > 0
+}
+
+func (q *SpillQueue) Append(evt DocEvent) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrSpillClosed
+	}
+	if q.maxBytes > 0 && q.total+int64(len(b)) > q.maxBytes {
+		q.dropped.Add(1)
+		return ErrSpillFull
+	}
+	if q.w == nil || q.segments[len(q.segments)-1].size+int64(len(b)) > q.segmentBytes {
+		if err := q.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := q.w.Write(b)
+	last := &q.segments[len(q.segments)-1]
+	last.size += int64(n)
+	q.total += int64(n)
+	if err != nil {
+		return err
+	}
+	q.spilled.Add(1)
+	return nil
+}
+
+// 关闭当前写入的段，开一个新段
+func (q *SpillQueue) rotateLocked() error {
+	if q.w != nil {
+		if err := q.w.Close(); err != nil {
+			log.Printf("spill queue: close segment %d error: %v", q.wSeq, err)
+		}
+		q.w = nil
+	}
+	seq := q.nextSeq
+	f, err := os.OpenFile(q.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	q.nextSeq++
+	q.w, q.wSeq = f, seq
+	q.segments = append(q.segments, spillSegment{seq: seq})
+	return nil
+}
+
+// 取出最老的段用于回放；如果它正在被写入，先封口（之后的写入进入新段）
+func (q *SpillQueue) oldest() (spillSegment, int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.segments) == 0 {
+		return spillSegment{}, 0, false
+	}
+	seg := q.segments[0]
+	if q.w != nil && q.wSeq == seg.seq {
+		if err := q.w.Close(); err != nil {
+			log.Printf("spill queue: close segment %d error: %v", seg.seq, err)
+		}
+		q.w = nil
+	}
+	return seg, q.replayOffset, true
+}
+
+func (q *SpillQueue) advance(offset int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.replayOffset = offset
+}
+
+func (q *SpillQueue) removeOldest(seg spillSegment) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := os.Remove(q.segmentPath(seg.seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("spill queue: remove segment %d error: %v", seg.seq, err)
+	}
+	q.segments = q.segments[1:]
+	q.total -= seg.size
+	q.replayOffset = 0
+}
+
+// Replay 按顺序把积压事件交给 send，直到全部发完或 send 返回错误（例如 Kafka 仍不可用）。
+// 发送失败的事件留在队列里，下次从该事件继续。不能并发调用。
+func (q *SpillQueue) Replay(send func(DocEvent) error) error {
+	for {
+		seg, offset, ok := q.oldest()
+		if !ok {
+			return nil
+		}
+		if err := q.replaySegment(seg, offset, send); err != nil {
+			return err
+		}
+		q.removeOldest(seg)
+	}
+}
+
+func (q *SpillQueue) replaySegment(seg spillSegment, offset int64, send func(DocEvent) error) error {
+	f, err := os.Open(q.segmentPath(seg.seq))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var evt DocEvent
+			if jsonErr := json.Unmarshal(line, &evt); jsonErr != nil {
+				log.Printf("spill queue: skip corrupt record in segment %d at %d: %v", seg.seq, offset, jsonErr)
+			} else if sendErr := send(evt); sendErr != nil {
+				return sendErr
+			} else {
+				q.replayed.Add(1)
+			}
+			offset += int64(len(line))
+			q.advance(offset)
+		}
+		if err == io.EOF {
+			// 末尾不完整的一行（写入时崩溃）直接丢弃
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (q *SpillQueue) Stats() SpillStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return SpillStats{
+		Spilled:  q.spilled.Load(),
+		Replayed: q.replayed.Load(),
+		Dropped:  q.dropped.Load(),
+		Bytes:    q.total,
+		Segments: len(q.segments),
+	}
+}
+
+// Close 关闭正在写入的段，之后的 Append 返回 ErrSpillClosed
+func (q *SpillQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	if q.w == nil {
+		return nil
+	}
+	err := q.w.Close()
+	q.w = nil
+	return err
+}
@@ -0,0 +1,95 @@
+package collab
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSpillQueue_ReplayInOrderAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	// 段很小，每段只放得下一两条，覆盖切段
+	q, err := OpenSpillQueue(dir, 0, 150)
+	if err != nil {
+		t.Fatalf("OpenSpillQueue() error = %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := q.Append(opEvent("doc", uint64(i))); err != nil {
+			t.Fatalf("Append(%d) error = %v", i, err)
+		}
+	}
+	if stats := q.Stats(); stats.Segments < 2 || stats.Spilled != 5 {
+		t.Fatalf("Stats() = %+v, want >= 2 segments, 5 spilled", stats)
+	}
+
+	// 第 3 条发送失败：前两条已发出，停在第 3 条
+	var got []uint64
+	errKafkaDown := errors.New("kafka down")
+	err = q.Replay(func(evt DocEvent) error {
+		if eventRevision(evt) == 3 {
+			return errKafkaDown
+		}
+		got = append(got, eventRevision(evt))
+		return nil
+	})
+	if !errors.Is(err, errKafkaDown) {
+		t.Fatalf("Replay() error = %v, want kafka down", err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// 重启后继续回放：已删除的段不再重复，同一段内可能重复（至少一次），顺序不乱
+	q, err = OpenSpillQueue(dir, 0, 150)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	if err := q.Replay(func(evt DocEvent) error {
+		got = append(got, eventRevision(evt))
+		return nil
+	}); err != nil {
+		t.Fatalf("Replay() after reopen error = %v", err)
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			t.Fatalf("replayed out of order: %v", got)
+		}
+	}
+	if got[len(got)-1] != 5 || q.HasBacklog() {
+		t.Fatalf("replayed %v, backlog %v; want through 5 with no backlog", got, q.HasBacklog())
+	}
+}
+
+func TestSpillQueue_RejectsOverMaxBytes(t *testing.T) {
+	q, err := OpenSpillQueue(t.TempDir(), 200, 0)
+	if err != nil {
+		t.Fatalf("OpenSpillQueue() error = %v", err)
+	}
+	defer q.Close()
+	var full int
+	for i := 0; i < 10; i++ {
+		if err := q.Append(opEvent("doc", uint64(i))); errors.Is(err, ErrSpillFull) {
+			full++
+		}
+	}
+	if stats := q.Stats(); full == 0 || stats.Bytes > 200 || stats.Dropped != uint64(full) {
+		t.Fatalf("Stats() = %+v with %d rejected, want bytes <= 200 and rejections counted", stats, full)
+	}
+}
+
+func TestSpillQueue_RejectsAppendAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenSpillQueue(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenSpillQueue() error = %v", err)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := q.Append(opEvent("doc", 1)); !errors.Is(err, ErrSpillClosed) {
+		t.Fatalf("Append() after Close error = %v, want ErrSpillClosed", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("spill dir has %d entries after Close, want none", len(entries))
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"collabServer/backend/internal/collab"
+)
+
+// 快照历史相关的 HTTP 接口
+// 回滚产生的新版本由协作引擎的 op 监听器按 revision 顺序推送给房间内的协作者
+type SnapshotHandler struct {
+	svc collab.Service
+}
+
+func NewSnapshotHandler(svc collab.Service) *SnapshotHandler {
+	return &SnapshotHandler{svc: svc}
+}
+
+// GET /documents/:docId/snapshots?limit=20
+func (h *SnapshotHandler) List(c *gin.Context) {
+	docID := c.Param("docId")
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	snaps, err := h.svc.ListSnapshots(c.Request.Context(), docID, limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"docId": docID, "snapshots": snaps})
+}
+
+// GET /documents/:docId/snapshots/:revision
+func (h *SnapshotHandler) Get(c *gin.Context) {
+	docID := c.Param("docId")
+	rev, err := strconv.ParseUint(c.Param("revision"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid revision"})
+		return
+	}
+	content, err := h.svc.GetSnapshot(c.Request.Context(), docID, rev)
+	if err != nil {
+		c.JSON(snapshotErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"docId": docID, "revision": rev, "content": content})
+}
+
+// POST /documents/:docId/snapshots/:revision/restore
+func (h *SnapshotHandler) Restore(c *gin.Context) {
+	docID := c.Param("docId")
+	rev, err := strconv.ParseUint(c.Param("revision"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid revision"})
+		return
+	}
+	applied, err := h.svc.RestoreSnapshot(c.Request.Context(), docID, c.GetUint64("userId"), rev)
+	if err != nil {
+		c.JSON(snapshotErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"docId": docID, "restoredFrom": rev, "revision": applied.Revision, "operationId": applied.OperationId})
+}
+
+// GET /documents/:docId/revisions/:revision
+// 文档在任意历史版本时的内容（不要求该版本恰好有快照），doc 为带样式的 delta
+func (h *SnapshotHandler) ContentAt(c *gin.Context) {
+	docID := c.Param("docId")
+	rev, err := strconv.ParseUint(c.Param("revision"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid revision"})
+		return
+	}
+	doc, err := h.svc.ContentAtRevision(c.Request.Context(), docID, rev)
+	if err != nil {
+		c.JSON(snapshotErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"docId": docID, "revision": rev, "content": doc.Text(), "doc": doc})
+}
+
+func snapshotErrorStatus(err error) int {
+	if errors.Is(err, collab.ErrSnapshotNotFound) || errors.Is(err, collab.ErrRevisionNotFound) {
+		return 404
+	}
+	return 500
+}
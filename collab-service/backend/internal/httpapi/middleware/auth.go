@@ -116,8 +116,16 @@ func AuthMiddleware(authBaseURL string) gin.HandlerFunc {
 			return
 		}
 
-		// 把用户信息写入gin.Context
-		c.Set("userId", claims.UserID)
+		if claims.UserID <= 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"code":    "UNAUTHENTICATED",
+				"message": "token has no user id",
+			})
+			return
+		}
+
+		// 把用户信息写入gin.Context；userId 统一存为 uint64，下游用 c.GetUint64("userId") 读取
+		c.Set("userId", uint64(claims.UserID))
 		c.Set("username", claims.Username)
 		c.Next()
 	}
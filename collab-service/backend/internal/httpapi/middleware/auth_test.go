@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuthMiddleware_SetsUint64UserID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"userId":42,"username":"alice","type":"access"}`))
+	}))
+	defer auth.Close()
+
+	r := gin.New()
+	r.Use(AuthMiddleware(auth.URL))
+	var userID uint64
+	var username string
+	r.GET("/", func(c *gin.Context) {
+		userID, username = c.GetUint64("userId"), c.GetString("username")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?token=t", nil))
+	if w.Code != http.StatusOK || userID != 42 || username != "alice" {
+		t.Fatalf("status = %d, userId = %d, username = %q; want 200, 42, alice", w.Code, userID, username)
+	}
+}
@@ -0,0 +1,73 @@
+package idgen
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Snowflake 风格的 64 位 ID：
+//
+//	| 1 bit 保留 | 41 bit 毫秒时间戳（相对 epoch） | 10 bit 节点 ID | 12 bit 毫秒内序号 |
+//
+// - 不同节点（实例）的 ID 不会重复，节点 ID 来自配置
+// - 同一节点内严格递增；不同节点之间按时间大致有序
+// - 单节点每毫秒最多 4096 个，用完时借用下一毫秒，不阻塞
+const (
+	nodeBits = 10
+	seqBits  = 12
+
+	MaxNodeID = 1<<nodeBits - 1
+	seqMask   = 1<<seqBits - 1
+)
+
+// 2024-01-01 00:00:00 UTC，41 bit 毫秒约可用 69 年
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type ID uint64
+
+func (id ID) String() string { return strconv.FormatUint(uint64(id), 10) }
+
+// 生成该 ID 的时间（毫秒精度）
+func (id ID) Time() time.Time {
+	return epoch.Add(time.Duration(uint64(id)>>(nodeBits+seqBits)) * time.Millisecond)
+}
+
+func (id ID) NodeID() int { return int(uint64(id) >> seqBits & MaxNodeID) }
+
+type Generator struct {
+	mu     sync.Mutex
+	nodeID uint64
+	lastMs int64
+	seq    uint64
+}
+
+func New(nodeID int) (*Generator, error) {
+	if nodeID < 0 || nodeID > MaxNodeID {
+		return nil, fmt.Errorf("node id %d out of range [0, %d]", nodeID, MaxNodeID)
+	}
+	return &Generator{nodeID: uint64(nodeID)}, nil
+}
+
+func (g *Generator) Next() ID {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Since(epoch).Milliseconds()
+	if now < g.lastMs {
+		// 时钟回拨：继续使用上一次的毫秒，保证单调递增
+		now = g.lastMs
+	}
+	if now == g.lastMs {
+		g.seq = (g.seq + 1) & seqMask
+		if g.seq == 0 {
+			// 本毫秒序号用完，借用下一毫秒
+			now++
+		}
+	} else {
+		g.seq = 0
+	}
+	g.lastMs = now
+	return ID(uint64(now)<<(nodeBits+seqBits) | g.nodeID<<seqBits | g.seq)
+}
@@ -0,0 +1,59 @@
+package idgen
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGenerator_UniqueAndIncreasing(t *testing.T) {
+	g, err := New(7)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	// 超过单毫秒容量，覆盖借用下一毫秒的情况
+	var prev ID
+	for i := 0; i < 3*(seqMask+1); i++ {
+		id := g.Next()
+		if id <= prev {
+			t.Fatalf("id %d not greater than previous %d", id, prev)
+		}
+		if id.NodeID() != 7 {
+			t.Fatalf("NodeID() = %d, want 7", id.NodeID())
+		}
+		prev = id
+	}
+	if d := time.Until(prev.Time()); d > time.Second {
+		t.Fatalf("Time() is %v ahead of now", d)
+	}
+}
+
+func TestGenerator_ConcurrentNodes(t *testing.T) {
+	a, _ := New(1)
+	b, _ := New(2)
+	var mu sync.Mutex
+	seen := make(map[ID]bool)
+	var wg sync.WaitGroup
+	for _, g := range []*Generator{a, a, b, b} {
+		wg.Add(1)
+		go func(g *Generator) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				id := g.Next()
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("duplicate id %d", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestNew_RejectsOutOfRangeNode(t *testing.T) {
+	if _, err := New(MaxNodeID + 1); err == nil {
+		t.Fatal("New(MaxNodeID+1) error = nil, want error")
+	}
+}
@@ -0,0 +1,60 @@
+package delta
+
+// Compose 把先后发生的 a、b 合并成一个等价的 delta：对任意文档 doc，
+// 应用 Compose(a, b) 与先应用 a 再应用 b 结果相同。
+func Compose(a, b Delta) Delta {
+	thisIter := newIterator(a)
+	otherIter := newIterator(b)
+	var out Delta
+
+	for thisIter.hasNext() || otherIter.hasNext() {
+		if otherIter.peekKind() == KindInsert {
+			out = appendOp(out, otherIter.next(0))
+			continue
+		}
+		if thisIter.peekKind() == KindDelete {
+			out = appendOp(out, thisIter.next(0))
+			continue
+		}
+
+		length := min(thisIter.peekLength(), otherIter.peekLength())
+		thisOp := thisIter.next(length)
+		otherOp := otherIter.next(length)
+		switch otherOp.Kind {
+		case KindRetain:
+			op := Op{Kind: KindRetain, Count: length}
+			if thisOp.Kind == KindInsert {
+				op = Op{Kind: KindInsert, Text: thisOp.Text}
+			}
+			// 作用在 retain 上的 null 需要保留，用来清除原文档里的样式
+			op.Attrs = ComposeAttrs(thisOp.Attrs, otherOp.Attrs, thisOp.Kind == KindRetain)
+			out = appendOp(out, op)
+		case KindDelete:
+			// b 删除了 a 插入的内容时两者抵消，什么也不输出
+			if thisOp.Kind == KindRetain {
+				out = appendOp(out, otherOp)
+			}
+		}
+	}
+	return chop(out)
+}
+
+// ComposeAttrs 合并两层样式属性：b 覆盖 a，值为 nil 表示移除该样式；
+// keepNull 为 false 时结果中不保留值为 nil 的 key（a、b 中的都不保留）
+func ComposeAttrs(a, b map[string]any, keepNull bool) map[string]any {
+	out := make(map[string]any, len(a)+len(b))
+	for k, v := range b {
+		if v != nil || keepNull {
+			out[k] = v
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok && (v != nil || keepNull) {
+			out[k] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
@@ -1,5 +1,7 @@
 package delta
 
+import "strings"
+
 type Kind string
 
 const (
@@ -17,4 +19,58 @@ type Op struct {
 
 type Delta []Op
 
+// op 占用的长度（rune 数）：insert 为文本长度，retain/delete 为 Count
+func (o Op) Len() int {
+	if o.Kind == KindInsert {
+		return len([]rune(o.Text))
+	}
+	return o.Count
+}
+
+// BaseLength 返回该 delta 可以作用的文档长度（retain + delete）
+func (d Delta) BaseLength() int {
+	n := 0
+	for _, op := range d {
+		if op.Kind != KindInsert {
+			n += op.Count
+		}
+	}
+	return n
+}
+
+// TargetLength 返回应用该 delta 之后的文档长度（retain + insert）
+func (d Delta) TargetLength() int {
+	n := 0
+	for _, op := range d {
+		if op.Kind != KindDelete {
+			n += op.Len()
+		}
+	}
+	return n
+}
+
+// Text 返回文档 delta（只含 insert）的纯文本
+func (d Delta) Text() string {
+	var sb strings.Builder
+	for _, op := range d {
+		if op.Kind == KindInsert {
+			sb.WriteString(op.Text)
+		}
+	}
+	return sb.String()
+}
+
+// Normalize 合并相邻的同类型、同属性 op，丢弃零长度 op
+func Normalize(d Delta) Delta {
+	var out Delta
+	for _, op := range d {
+		if len(op.Attrs) == 0 {
+			op.Attrs = nil
+		}
+		out = appendOp(out, op)
+	}
+	return out
+}
+
 // "ops":[{"retain":5},{"insert":"Hello"}]
+// 解码同时兼容上面的 Quill 风格与本包的 {"kind":"retain","count":5} 风格，见 json.go
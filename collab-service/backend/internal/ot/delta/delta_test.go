@@ -0,0 +1,98 @@
+package delta
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompose_InsertThenDelete(t *testing.T) {
+	a := Delta{{Kind: KindRetain, Count: 5}, {Kind: KindInsert, Text: " big"}}
+	b := Delta{{Kind: KindRetain, Count: 6}, {Kind: KindDelete, Count: 3}, {Kind: KindInsert, Text: "small"}}
+
+	base := "Hello world"
+	want := applyToString(applyToString(base, a), b)
+	if got := applyToString(base, Compose(a, b)); got != want {
+		t.Fatalf("apply(Compose(a, b)) = %q, want %q", got, want)
+	}
+}
+
+func TestCompose_Attributes(t *testing.T) {
+	a := Delta{{Kind: KindInsert, Text: "Hi", Attrs: map[string]any{"bold": true}}}
+	b := Delta{{Kind: KindRetain, Count: 2, Attrs: map[string]any{"bold": nil, "italic": true}}}
+
+	got := Compose(a, b)
+	want := Delta{{Kind: KindInsert, Text: "Hi", Attrs: map[string]any{"italic": true}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Compose() = %+v, want %+v", got, want)
+	}
+}
+
+// 两个 retain 合并时，a 中的 null（清除样式）不能丢
+func TestCompose_RetainsKeepNullFromFirst(t *testing.T) {
+	a := Delta{{Kind: KindRetain, Count: 3, Attrs: map[string]any{"bold": nil}}}
+	b := Delta{{Kind: KindRetain, Count: 3, Attrs: map[string]any{"italic": true}}}
+
+	got := Compose(a, b)
+	want := Delta{{Kind: KindRetain, Count: 3, Attrs: map[string]any{"bold": nil, "italic": true}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Compose() = %+v, want %+v", got, want)
+	}
+}
+
+func TestInvert_RestoresBase(t *testing.T) {
+	base := Delta{{Kind: KindInsert, Text: "Hello "}, {Kind: KindInsert, Text: "world", Attrs: map[string]any{"bold": true}}}
+	d := Delta{
+		{Kind: KindRetain, Count: 2},
+		{Kind: KindDelete, Count: 4},
+		{Kind: KindInsert, Text: "y"},
+		{Kind: KindRetain, Count: 5, Attrs: map[string]any{"bold": nil, "color": "red"}},
+	}
+
+	restored := Compose(Compose(base, d), Invert(d, base))
+	if !reflect.DeepEqual(restored, Normalize(base)) {
+		t.Fatalf("base ∘ d ∘ invert(d) = %+v, want %+v", restored, base)
+	}
+}
+
+func TestNormalize_MergesAndDropsEmpty(t *testing.T) {
+	d := Delta{
+		{Kind: KindRetain, Count: 2},
+		{Kind: KindRetain, Count: 0},
+		{Kind: KindRetain, Count: 3},
+		{Kind: KindInsert, Text: ""},
+		{Kind: KindInsert, Text: "a", Attrs: map[string]any{}},
+		{Kind: KindInsert, Text: "b"},
+	}
+	want := Delta{{Kind: KindRetain, Count: 5}, {Kind: KindInsert, Text: "ab"}}
+	if got := Normalize(d); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Normalize() = %+v, want %+v", got, want)
+	}
+	if got := want.BaseLength(); got != 5 {
+		t.Fatalf("BaseLength() = %d, want 5", got)
+	}
+	if got := want.TargetLength(); got != 7 {
+		t.Fatalf("TargetLength() = %d, want 7", got)
+	}
+}
+
+func TestUnmarshalJSON_QuillAndNative(t *testing.T) {
+	raw := `[{"retain":5},{"insert":"Hello","attributes":{"bold":true}},{"delete":2},{"kind":"insert","text":"!"}]`
+	var d Delta
+	if err := json.Unmarshal([]byte(raw), &d); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Delta{
+		{Kind: KindRetain, Count: 5},
+		{Kind: KindInsert, Text: "Hello", Attrs: map[string]any{"bold": true}},
+		{Kind: KindDelete, Count: 2},
+		{Kind: KindInsert, Text: "!"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Fatalf("Unmarshal() = %+v, want %+v", d, want)
+	}
+
+	if err := json.Unmarshal([]byte(`[{"foo":1}]`), &d); err == nil {
+		t.Fatalf("Unmarshal() of unknown op succeeded, want error")
+	}
+}
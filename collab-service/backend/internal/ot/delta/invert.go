@@ -0,0 +1,58 @@
+package delta
+
+import "reflect"
+
+// Invert 返回 d 的逆操作：base 为应用 d 之前的文档（只含 insert 的 delta），
+// 在应用 d 之后的文档上再应用 Invert(d, base) 可以还原出 base。用于撤销/回滚。
+func Invert(d, base Delta) Delta {
+	var out Delta
+	baseIter := newIterator(base)
+	for _, op := range d {
+		switch {
+		case op.Kind == KindInsert:
+			out = appendOp(out, Op{Kind: KindDelete, Count: op.Len()})
+		case op.Kind == KindRetain && len(op.Attrs) == 0:
+			out = appendOp(out, Op{Kind: KindRetain, Count: op.Count})
+			skip(baseIter, op.Count)
+		default:
+			// delete 需要把原文还原回来；带属性的 retain 需要把原样式还原回来
+			remain := op.Count
+			for remain > 0 && baseIter.hasNext() {
+				baseOp := baseIter.next(remain)
+				remain -= baseOp.Len()
+				if op.Kind == KindDelete {
+					out = appendOp(out, baseOp)
+				} else {
+					out = appendOp(out, Op{Kind: KindRetain, Count: baseOp.Len(),
+						Attrs: invertAttrs(op.Attrs, baseOp.Attrs)})
+				}
+			}
+		}
+	}
+	return chop(out)
+}
+
+func skip(it *iterator, length int) {
+	for length > 0 && it.hasNext() {
+		length -= it.next(length).Len()
+	}
+}
+
+// 计算把 attrs 还原为 base 所需的属性：被改过的 key 恢复原值，新增的 key 置 nil
+func invertAttrs(attrs, base map[string]any) map[string]any {
+	out := make(map[string]any)
+	for k, v := range base {
+		if nv, ok := attrs[k]; ok && !reflect.DeepEqual(nv, v) {
+			out[k] = v
+		}
+	}
+	for k := range attrs {
+		if _, ok := base[k]; !ok {
+			out[k] = nil
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
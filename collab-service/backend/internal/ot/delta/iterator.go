@@ -0,0 +1,67 @@
+package delta
+
+import "math"
+
+// 按长度逐段消费 Delta 的迭代器，Transform/Compose 等算法都基于它实现。
+// 长度统一按 rune 计算，与 PieceTable 的位置语义保持一致。
+type iterator struct {
+	ops    Delta
+	index  int // 当前所在 op 下标
+	offset int // 当前 op 内已消费的长度
+}
+
+func newIterator(d Delta) *iterator {
+	return &iterator{ops: d}
+}
+
+func (it *iterator) hasNext() bool {
+	return it.peekLength() < math.MaxInt
+}
+
+// 当前 op 剩余可消费的长度；迭代结束时返回 MaxInt（视为无限长的 retain）
+func (it *iterator) peekLength() int {
+	if it.index >= len(it.ops) {
+		return math.MaxInt
+	}
+	return it.ops[it.index].Len() - it.offset
+}
+
+// 当前 op 的类型；迭代结束时视为 retain
+func (it *iterator) peekKind() Kind {
+	if it.index >= len(it.ops) {
+		return KindRetain
+	}
+	return it.ops[it.index].Kind
+}
+
+// 取出至多 length 长度的一段 op，length <= 0 表示取完当前 op 剩余部分
+func (it *iterator) next(length int) Op {
+	if it.index >= len(it.ops) {
+		if length <= 0 {
+			length = math.MaxInt
+		}
+		return Op{Kind: KindRetain, Count: length}
+	}
+	cur := it.ops[it.index]
+	remain := cur.Len() - it.offset
+	if length <= 0 || length >= remain {
+		length = remain
+	}
+	offset := it.offset
+	if length == remain {
+		it.index++
+		it.offset = 0
+	} else {
+		it.offset += length
+	}
+
+	switch cur.Kind {
+	case KindInsert:
+		r := []rune(cur.Text)
+		return Op{Kind: KindInsert, Text: string(r[offset : offset+length]), Attrs: cur.Attrs}
+	case KindDelete:
+		return Op{Kind: KindDelete, Count: length}
+	default:
+		return Op{Kind: KindRetain, Count: length, Attrs: cur.Attrs}
+	}
+}
@@ -0,0 +1,50 @@
+package delta
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 线上兼容的两种编码：
+//   - 本包原生：{"kind":"insert","text":"Hi","attrs":{"bold":true}}
+//   - Quill 风格：{"insert":"Hi","attributes":{"bold":true}}、{"retain":5}、{"delete":3}
+//
+// 编码（MarshalJSON）保持原生格式，解码两种都接受。
+type wireOp struct {
+	Kind  Kind           `json:"kind"`
+	Count int            `json:"count"`
+	Text  string         `json:"text"`
+	Attrs map[string]any `json:"attrs"`
+
+	Retain     *int           `json:"retain"`
+	Insert     *string        `json:"insert"`
+	Delete     *int           `json:"delete"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+func (o *Op) UnmarshalJSON(b []byte) error {
+	var w wireOp
+	if err := json.Unmarshal(b, &w); err != nil {
+		return err
+	}
+	if w.Kind != "" {
+		*o = Op{Kind: w.Kind, Count: w.Count, Text: w.Text, Attrs: w.Attrs}
+		return nil
+	}
+
+	attrs := w.Attributes
+	if attrs == nil {
+		attrs = w.Attrs
+	}
+	switch {
+	case w.Insert != nil:
+		*o = Op{Kind: KindInsert, Text: *w.Insert, Attrs: attrs}
+	case w.Retain != nil:
+		*o = Op{Kind: KindRetain, Count: *w.Retain, Attrs: attrs}
+	case w.Delete != nil:
+		*o = Op{Kind: KindDelete, Count: *w.Delete}
+	default:
+		return fmt.Errorf("delta: unrecognized op %s", string(b))
+	}
+	return nil
+}
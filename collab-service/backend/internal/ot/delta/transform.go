@@ -0,0 +1,99 @@
+package delta
+
+import "reflect"
+
+// Transform 把 b 变换到 a 之后：a、b 基于同一版本并发产生，
+// 返回的 b' 可以直接应用在「已应用 a」的文档上，且与先 b 后 a' 收敛到相同结果。
+// priority 为 true 表示 a 先发生：同一位置的并发插入，a 的内容排在前面。
+func Transform(a, b Delta, priority bool) Delta {
+	thisIter := newIterator(a)
+	otherIter := newIterator(b)
+	var out Delta
+
+	for thisIter.hasNext() || otherIter.hasNext() {
+		if thisIter.peekKind() == KindInsert && (priority || otherIter.peekKind() != KindInsert) {
+			// a 插入的文本对 b 来说是需要跳过的内容
+			out = appendOp(out, Op{Kind: KindRetain, Count: thisIter.next(0).Len()})
+			continue
+		}
+		if otherIter.peekKind() == KindInsert {
+			out = appendOp(out, otherIter.next(0))
+			continue
+		}
+
+		length := min(thisIter.peekLength(), otherIter.peekLength())
+		thisOp := thisIter.next(length)
+		otherOp := otherIter.next(length)
+		switch {
+		case thisOp.Kind == KindDelete:
+			// a 已经删掉了这段，b 对它的 retain/delete 都失去意义
+		case otherOp.Kind == KindDelete:
+			out = appendOp(out, otherOp)
+		default:
+			out = appendOp(out, Op{Kind: KindRetain, Count: length,
+				Attrs: transformAttrs(thisOp.Attrs, otherOp.Attrs, priority)})
+		}
+	}
+	return chop(out)
+}
+
+// 样式属性变换：a 优先时，b 中与 a 冲突的 key 以 a 为准（从 b 中去掉）
+func transformAttrs(a, b map[string]any, priority bool) map[string]any {
+	if len(b) == 0 {
+		return nil
+	}
+	if !priority || len(a) == 0 {
+		return b
+	}
+	out := make(map[string]any, len(b))
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			out[k] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// 追加一个 op，和末尾同类型、同属性的 op 合并；零长度 op 直接丢弃
+func appendOp(d Delta, op Op) Delta {
+	if op.Len() <= 0 {
+		return d
+	}
+	if n := len(d); n > 0 {
+		last := &d[n-1]
+		if last.Kind == op.Kind && attrsEqual(last.Attrs, op.Attrs) {
+			switch op.Kind {
+			case KindInsert:
+				last.Text += op.Text
+			default:
+				last.Count += op.Count
+			}
+			return d
+		}
+		// 同一位置 insert 与 delete 先后顺序无关，统一把 insert 放在 delete 前面
+		if last.Kind == KindDelete && op.Kind == KindInsert {
+			del := *last
+			d = appendOp(d[:n-1], op)
+			return append(d, del)
+		}
+	}
+	return append(d, op)
+}
+
+// 去掉末尾不带属性的 retain（对结果没有影响）
+func chop(d Delta) Delta {
+	if n := len(d); n > 0 && d[n-1].Kind == KindRetain && len(d[n-1].Attrs) == 0 {
+		return d[:n-1]
+	}
+	return d
+}
+
+func attrsEqual(a, b map[string]any) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,75 @@
+package delta
+
+import (
+	"reflect"
+	"testing"
+)
+
+// 用最朴素的方式把 delta 应用到字符串上，仅用于测试
+func applyToString(s string, d Delta) string {
+	r := []rune(s)
+	var out []rune
+	pos := 0
+	for _, op := range d {
+		switch op.Kind {
+		case KindRetain:
+			out = append(out, r[pos:pos+op.Count]...)
+			pos += op.Count
+		case KindInsert:
+			out = append(out, []rune(op.Text)...)
+		case KindDelete:
+			pos += op.Count
+		}
+	}
+	return string(append(out, r[pos:]...))
+}
+
+func TestTransform_ConcurrentInsertsSamePosition(t *testing.T) {
+	a := Delta{{Kind: KindRetain, Count: 5}, {Kind: KindInsert, Text: "A"}}
+	b := Delta{{Kind: KindRetain, Count: 5}, {Kind: KindInsert, Text: "B"}}
+
+	got := Transform(a, b, true)
+	want := Delta{{Kind: KindRetain, Count: 6}, {Kind: KindInsert, Text: "B"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Transform(a, b, true) = %+v, want %+v", got, want)
+	}
+
+	got = Transform(a, b, false)
+	want = Delta{{Kind: KindRetain, Count: 5}, {Kind: KindInsert, Text: "B"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Transform(a, b, false) = %+v, want %+v", got, want)
+	}
+}
+
+func TestTransform_Converges(t *testing.T) {
+	base := "Hello world"
+	cases := []struct {
+		name string
+		a, b Delta
+	}{
+		{
+			name: "insert vs delete",
+			a:    Delta{{Kind: KindRetain, Count: 6}, {Kind: KindInsert, Text: "big "}},
+			b:    Delta{{Kind: KindRetain, Count: 5}, {Kind: KindDelete, Count: 6}},
+		},
+		{
+			name: "overlapping deletes",
+			a:    Delta{{Kind: KindRetain, Count: 2}, {Kind: KindDelete, Count: 5}},
+			b:    Delta{{Kind: KindRetain, Count: 4}, {Kind: KindDelete, Count: 5}},
+		},
+		{
+			name: "unicode inserts",
+			a:    Delta{{Kind: KindInsert, Text: "你好，"}},
+			b:    Delta{{Kind: KindRetain, Count: 11}, {Kind: KindInsert, Text: "！"}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			left := applyToString(applyToString(base, tc.a), Transform(tc.a, tc.b, true))
+			right := applyToString(applyToString(base, tc.b), Transform(tc.b, tc.a, false))
+			if left != right {
+				t.Fatalf("diverged: a then b' = %q, b then a' = %q", left, right)
+			}
+		})
+	}
+}
@@ -3,32 +3,156 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/go-sql-driver/mysql"
+
+	"collabServer/backend/internal/collab"
+	"collabServer/backend/internal/ot/delta"
 )
 
+// document_snapshots 除纯文本 content 外还保存带样式的完整文档：
+//
+//	ALTER TABLE document_snapshots ADD COLUMN doc JSON NULL;
+//
+// 加列之前写入的快照 doc 为 NULL，读取时按纯文本 content 处理（没有样式）。
 type SnapshotStore struct{ db *sql.DB }
 
 func NewSnapshotStore(db *sql.DB) *SnapshotStore {
 	return &SnapshotStore{db: db}
 }
 
-func (s *SnapshotStore) SaveDocumentSnapshot(ctx context.Context, docID string, rev uint64, content string) error {
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO document_snapshots (document_id, revision, content)
-		VALUES (?, ?, ?)`,
+func (s *SnapshotStore) SaveDocumentSnapshot(ctx context.Context, docID string, rev uint64, doc delta.Delta, fence uint64) error {
+	return withFence(ctx, s.db, docID, fence, func(e execer) error {
+		_, err := saveSnapshot(ctx, e, docID, rev, doc)
+		return err
+	})
+}
+
+// 写入一条快照，返回是否真正插入（同一版本已存在时视为成功但不插入）
+func saveSnapshot(ctx context.Context, e execer, docID string, rev uint64, doc delta.Delta) (bool, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+	_, err = e.ExecContext(ctx,
+		`INSERT INTO document_snapshots (document_id, revision, content, doc)
+		VALUES (?, ?, ?, ?)`,
 		docID,
 		rev,
-		content,
+		doc.Text(),
+		raw,
 	)
 	if err != nil {
 		var mysqlErr *mysql.MySQLError
 		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
-			return nil
+			return false, nil
 			// return fmt.Errorf("duplicate snapshot for doc %s rev %d: %w", docID, rev, err)
 		}
-		return err
+		return false, err
+	}
+	return true, nil
+}
+
+// 从 content / doc 两列还原带样式的文档；doc 为 NULL（旧快照）时退化为纯文本
+func decodeSnapshotDoc(docID string, rev uint64, content string, raw []byte) (delta.Delta, error) {
+	if raw == nil {
+		if content == "" {
+			return nil, nil
+		}
+		return delta.Delta{{Kind: delta.KindInsert, Text: content}}, nil
+	}
+	var doc delta.Delta
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("decode snapshot for doc %s rev %d: %w", docID, rev, err)
+	}
+	return doc, nil
+}
+
+// 读取文档最新一条快照；没有快照时返回 (nil, 0, nil)
+func (s *SnapshotStore) LoadLatestSnapshot(ctx context.Context, docID string) (delta.Delta, uint64, error) {
+	var content string
+	var raw []byte
+	var rev uint64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT content, doc, revision FROM document_snapshots
+		WHERE document_id = ? ORDER BY revision DESC LIMIT 1`,
+		docID,
+	).Scan(&content, &raw, &rev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	doc, err := decodeSnapshotDoc(docID, rev, content, raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, rev, nil
+}
+
+// 按版本倒序列出快照元信息，limit <= 0 表示不限制条数
+func (s *SnapshotStore) ListSnapshots(ctx context.Context, docID string, limit int) ([]collab.SnapshotInfo, error) {
+	query := `SELECT revision, created_at, CHAR_LENGTH(content) FROM document_snapshots
+		WHERE document_id = ? ORDER BY revision DESC`
+	args := []any{docID}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []collab.SnapshotInfo
+	for rows.Next() {
+		var info collab.SnapshotInfo
+		if err := rows.Scan(&info.Revision, &info.CreatedAt, &info.Size); err != nil {
+			return nil, err
+		}
+		out = append(out, info)
+	}
+	return out, rows.Err()
+}
+
+func (s *SnapshotStore) LoadSnapshot(ctx context.Context, docID string, rev uint64) (string, error) {
+	var content string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT content FROM document_snapshots WHERE document_id = ? AND revision = ?`,
+		docID,
+		rev,
+	).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", collab.ErrSnapshotNotFound
+	}
+	return content, err
+}
+
+// 读取版本 <= rev 的最近一条快照；没有时返回 (nil, 0, nil)
+func (s *SnapshotStore) LoadSnapshotAtOrBefore(ctx context.Context, docID string, rev uint64) (delta.Delta, uint64, error) {
+	var content string
+	var raw []byte
+	var snapRev uint64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT content, doc, revision FROM document_snapshots
+		WHERE document_id = ? AND revision <= ? ORDER BY revision DESC LIMIT 1`,
+		docID,
+		rev,
+	).Scan(&content, &raw, &snapRev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	doc, err := decodeSnapshotDoc(docID, snapRev, content, raw)
+	if err != nil {
+		return nil, 0, err
 	}
-	return nil
+	return doc, snapRev, nil
 }
@@ -5,6 +5,13 @@ import (
 	"database/sql"
 )
 
+// documents.id 由应用生成（Snowflake，uint64），必须是不带 AUTO_INCREMENT 的 BIGINT UNSIGNED 主键：
+// INT 装不下 Snowflake ID；保留 AUTO_INCREMENT 时写入的 ID 会把自增计数推到很大，混用自增就会冲突。
+// 原来使用自增主键的表：
+//
+//	ALTER TABLE documents MODIFY COLUMN id BIGINT UNSIGNED NOT NULL;
+//
+// 引用 documents.id 的外键列需要同样改为 BIGINT UNSIGNED。
 type DocumentStore struct{ db *sql.DB }
 
 func NewDocumentStore(db *sql.DB) *DocumentStore {
@@ -21,9 +28,11 @@ func (s *DocumentStore) GetDocumentID(ctx context.Context, title string) (string
 	return docID, err
 }
 
-func (s *DocumentStore) CreateDocument(ctx context.Context, ownerID uint64, title string) error {
+// docID 由调用方生成（Snowflake），不依赖数据库自增
+func (s *DocumentStore) CreateDocument(ctx context.Context, docID string, ownerID uint64, title string) error {
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO documents (owner_id, title) VALUES (?, ?)`,
+		`INSERT INTO documents (id, owner_id, title) VALUES (?, ?, ?)`,
+		docID,
 		ownerID,
 		title,
 	)
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"collabServer/backend/internal/collab"
+)
+
+// 每个文档见过的最大租约 token，写操作日志 / 快照前在同一事务里校验（fencing）：
+//
+//	CREATE TABLE document_fences (
+//	  document_id VARCHAR(64)     NOT NULL,
+//	  fence       BIGINT UNSIGNED NOT NULL,
+//	  PRIMARY KEY (document_id)
+//	);
+//
+// 持有更新 token 的实例一旦写过，旧 owner 的写入都会被拒绝。fence 为 0（单实例部署）时不校验。
+func checkFence(ctx context.Context, tx *sql.Tx, docID string, fence uint64) error {
+	if fence == 0 {
+		return nil
+	}
+	// upsert 同时锁住该行，并发写入同一文档时依次校验
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO document_fences (document_id, fence) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE fence = GREATEST(fence, VALUES(fence))`,
+		docID,
+		fence,
+	); err != nil {
+		return err
+	}
+	var current uint64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT fence FROM document_fences WHERE document_id = ?`,
+		docID,
+	).Scan(&current); err != nil {
+		return err
+	}
+	if current > fence {
+		return fmt.Errorf("%w: doc %s fence %d, writer holds %d", collab.ErrFenced, docID, current, fence)
+	}
+	return nil
+}
+
+// 在事务里校验 fence 后执行 fn；fence 为 0 时直接在 db 上执行
+func withFence(ctx context.Context, db *sql.DB, docID string, fence uint64, fn func(e execer) error) error {
+	if fence == 0 {
+		return fn(db)
+	}
+	return withTx(ctx, db, func(tx *sql.Tx) error {
+		if err := checkFence(ctx, tx, docID, fence); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+
+	"collabServer/backend/internal/collab"
+	"collabServer/backend/internal/ot/delta"
+)
+
+// 操作日志表，每个已应用的操作一行，(document_id, revision) 唯一：
+//
+//	CREATE TABLE document_ops (
+//	  document_id  VARCHAR(64)     NOT NULL,
+//	  revision     BIGINT UNSIGNED NOT NULL,
+//	  operation_id VARCHAR(64)     NOT NULL,
+//	  author_id    BIGINT UNSIGNED NOT NULL,
+//	  client_id    VARCHAR(64)     NOT NULL DEFAULT '',
+//	  client_seq   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+//	  ops          JSON            NOT NULL,
+//	  applied_at   DATETIME(6)     NOT NULL,
+//	  PRIMARY KEY (document_id, revision)
+//	);
+type OpLogStore struct{ db *sql.DB }
+
+func NewOpLogStore(db *sql.DB) *OpLogStore {
+	return &OpLogStore{db: db}
+}
+
+func (s *OpLogStore) AppendOp(ctx context.Context, docID string, op collab.AppliedOp, fence uint64) error {
+	return withFence(ctx, s.db, docID, fence, func(e execer) error {
+		return appendOp(ctx, e, docID, op)
+	})
+}
+
+// *sql.DB 与 *sql.Tx 都实现了它，同一段写入逻辑可以放进事务
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func appendOp(ctx context.Context, e execer, docID string, op collab.AppliedOp) error {
+	ops, err := json.Marshal(op.Ops)
+	if err != nil {
+		return err
+	}
+	_, err = e.ExecContext(ctx,
+		`INSERT INTO document_ops (document_id, revision, operation_id, author_id, client_id, client_seq, ops, applied_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		docID,
+		op.Revision,
+		op.OperationId,
+		op.AuthorId,
+		op.ClientId,
+		op.ClientSeq,
+		ops,
+		op.AppliedAt,
+	)
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == 1062 {
+			return fmt.Errorf("duplicate op for doc %s rev %d: %w", docID, op.Revision, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// LoadOps 按版本升序返回 fromRevision 之后的操作，limit <= 0 表示不限制条数
+func (s *OpLogStore) LoadOps(ctx context.Context, docID string, fromRevision uint64, limit int) ([]collab.AppliedOp, error) {
+	query := `SELECT revision, operation_id, author_id, client_id, client_seq, ops, applied_at
+		FROM document_ops WHERE document_id = ? AND revision > ? ORDER BY revision`
+	args := []any{docID, fromRevision}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []collab.AppliedOp
+	for rows.Next() {
+		var op collab.AppliedOp
+		var raw []byte
+		if err := rows.Scan(&op.Revision, &op.OperationId, &op.AuthorId, &op.ClientId, &op.ClientSeq, &raw, &op.AppliedAt); err != nil {
+			return nil, err
+		}
+		var ops delta.Delta
+		if err := json.Unmarshal(raw, &ops); err != nil {
+			return nil, fmt.Errorf("decode ops for doc %s rev %d: %w", docID, op.Revision, err)
+		}
+		op.Ops = ops
+		out = append(out, op)
+	}
+	return out, rows.Err()
+}
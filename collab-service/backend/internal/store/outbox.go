@@ -0,0 +1,264 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"collabServer/backend/internal/collab"
+	"collabServer/backend/internal/ot/delta"
+)
+
+// 事务性 outbox 表：与操作日志 / 快照在同一个事务里写入，由 OutboxRelay 按 id 顺序发布到 Kafka：
+//
+//	CREATE TABLE document_outbox (
+//	  id           BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+//	  document_id  VARCHAR(64)     NOT NULL,
+//	  event_type   VARCHAR(32)     NOT NULL,
+//	  event_id     VARCHAR(64)     NOT NULL,
+//	  payload      JSON            NOT NULL,
+//	  created_at   DATETIME(6)     NOT NULL,
+//	  sent_at      DATETIME(6)     NULL,
+//	  claimed_by   VARCHAR(32)     NULL,
+//	  claim_until  DATETIME(6)     NULL,
+//	  PRIMARY KEY (id),
+//	  KEY idx_pending (sent_at, document_id, id)
+//	);
+//
+// 已有的表：ALTER TABLE document_outbox ADD COLUMN claimed_by VARCHAR(32) NULL, ADD COLUMN claim_until DATETIME(6) NULL;
+type OutboxStore struct {
+	db *sql.DB
+	// 本实例认领事件时写入 claimed_by 的标识
+	owner string
+}
+
+// 认领的有效期：每发出一条事件就给剩余事件续期一次，只有单条发送卡住（或进程崩溃）超过这个时间，
+// 其他实例才会重新认领。应明显大于 producer 单条发送的超时
+const outboxClaimTTL = 30 * time.Second
+
+func NewOutboxStore(db *sql.DB) *OutboxStore {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return &OutboxStore{db: db, owner: hex.EncodeToString(b)}
+}
+
+func insertOutbox(ctx context.Context, e execer, evt collab.DocEvent) error {
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	_, err = e.ExecContext(ctx,
+		`INSERT INTO document_outbox (document_id, event_type, event_id, payload, created_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		evt.DocID,
+		evt.EventType,
+		evt.EventID,
+		payload,
+		time.Now(),
+	)
+	return err
+}
+
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// AppendOpWithEvent 在同一个事务里写操作日志与对应的 outbox 事件
+func (s *OpLogStore) AppendOpWithEvent(ctx context.Context, docID string, op collab.AppliedOp, evt collab.DocEvent, fence uint64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := checkFence(ctx, tx, docID, fence); err != nil {
+			return err
+		}
+		if err := appendOp(ctx, tx, docID, op); err != nil {
+			return err
+		}
+		return insertOutbox(ctx, tx, evt)
+	})
+}
+
+// SaveDocumentSnapshotWithEvent 在同一个事务里写快照与对应的 outbox 事件；快照已存在时不重复写事件
+func (s *SnapshotStore) SaveDocumentSnapshotWithEvent(ctx context.Context, docID string, rev uint64, doc delta.Delta, evt collab.DocEvent, fence uint64) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := checkFence(ctx, tx, docID, fence); err != nil {
+			return err
+		}
+		inserted, err := saveSnapshot(ctx, tx, docID, rev, doc)
+		if err != nil || !inserted {
+			return err
+		}
+		return insertOutbox(ctx, tx, evt)
+	})
+}
+
+// Publish 把事件写入 outbox（实现 collab.EventPublisher）。outbox 模式下文档创建、成员加入 / 离开等
+// 没有对应数据写入的事件也经 outbox 按顺序发布
+func (s *OutboxStore) Publish(ctx context.Context, evt collab.DocEvent) error {
+	return insertOutbox(ctx, s.db, evt)
+}
+
+// PendingDocuments 返回有未发布事件的文档
+func (s *OutboxStore) PendingDocuments(ctx context.Context, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT DISTINCT document_id FROM document_outbox WHERE sent_at IS NULL LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docIDs []string
+	for rows.Next() {
+		var docID string
+		if err := rows.Scan(&docID); err != nil {
+			return nil, err
+		}
+		docIDs = append(docIDs, docID)
+	}
+	return docIDs, rows.Err()
+}
+
+// ProcessPending 在一个短事务里认领文档最早的 limit 条未发布事件（写 claimed_by / claim_until），
+// 提交后逐条交给 publish：每发出一条就标记为已发送，并把其余事件的认领续期，broker 再慢认领也不会在批次中途过期；
+// publish 失败时释放剩余的认领。发送 Kafka 期间不持有行锁；其他实例看到未过期的认领会跳过该文档，保证顺序。
+func (s *OutboxStore) ProcessPending(ctx context.Context, docID string, limit int, publish func(collab.OutboxRecord) error) error {
+	records, err := s.claimPending(ctx, docID, limit)
+	if err != nil || len(records) == 0 {
+		return err
+	}
+	for i, rec := range records {
+		if err := publish(rec); err != nil {
+			// 没发出去的释放认领，下一轮（任意实例）从这里继续
+			return s.updateClaimed(ctx, `claimed_by = NULL, claim_until = NULL`, nil, records[i:])
+		}
+		held, err := s.markSent(ctx, rec, records[i+1:])
+		if err != nil {
+			return err
+		}
+		if !held {
+			// 认领已过期并被其他实例接手：剩下的交给它，不再和它交错发送
+			return fmt.Errorf("outbox claim lost at outbox=%d, %d events left to the new claimer", rec.ID, len(records)-i-1)
+		}
+	}
+	return nil
+}
+
+// 把 sent 标记为已发送，并给 rest 的认领续期一个 outboxClaimTTL；sent 已不归本实例认领时返回 false
+func (s *OutboxStore) markSent(ctx context.Context, sent collab.OutboxRecord, rest []collab.OutboxRecord) (bool, error) {
+	held := false
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		res, err := tx.ExecContext(ctx,
+			`UPDATE document_outbox SET sent_at = ?, claimed_by = NULL, claim_until = NULL WHERE claimed_by = ? AND id = ?`,
+			now,
+			s.owner,
+			sent.ID,
+		)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if held = n == 1; !held || len(rest) == 0 {
+			return nil
+		}
+		args := append([]any{now.Add(outboxClaimTTL), s.owner}, outboxIDs(rest)...)
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf(`UPDATE document_outbox SET claim_until = ? WHERE claimed_by = ? AND id IN (%s)`, placeholders(len(rest))),
+			args...,
+		)
+		return err
+	})
+	return held, err
+}
+
+// 认领文档最早的未发布事件；最早的事件已被其他实例认领且未过期时返回空
+func (s *OutboxStore) claimPending(ctx context.Context, docID string, limit int) ([]collab.OutboxRecord, error) {
+	var records []collab.OutboxRecord
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`SELECT id, event_type, event_id, payload, claimed_by, claim_until FROM document_outbox
+			WHERE sent_at IS NULL AND document_id = ? ORDER BY id LIMIT ? FOR UPDATE`,
+			docID,
+			limit,
+		)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		claimedElsewhere := false
+		for rows.Next() {
+			r := collab.OutboxRecord{DocID: docID}
+			var claimedBy sql.NullString
+			var claimUntil sql.NullTime
+			if err := rows.Scan(&r.ID, &r.EventType, &r.EventID, &r.Payload, &claimedBy, &claimUntil); err != nil {
+				rows.Close()
+				return err
+			}
+			if claimedBy.Valid && claimedBy.String != s.owner && claimUntil.Valid && claimUntil.Time.After(now) {
+				claimedElsewhere = true
+				break
+			}
+			records = append(records, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if claimedElsewhere {
+			// 其他实例正在发布更早的事件，这一轮跳过该文档
+			records = nil
+			return nil
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		args := append([]any{s.owner, now.Add(outboxClaimTTL)}, outboxIDs(records)...)
+		_, err = tx.ExecContext(ctx,
+			fmt.Sprintf(`UPDATE document_outbox SET claimed_by = ?, claim_until = ? WHERE id IN (%s)`, placeholders(len(records))),
+			args...,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// 更新本实例认领的事件；认领已过期并被其他实例接手的行不受影响
+func (s *OutboxStore) updateClaimed(ctx context.Context, set string, setArgs []any, records []collab.OutboxRecord) error {
+	args := append(setArgs, s.owner)
+	args = append(args, outboxIDs(records)...)
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf(`UPDATE document_outbox SET %s WHERE claimed_by = ? AND id IN (%s)`, set, placeholders(len(records))),
+		args...,
+	)
+	return err
+}
+
+func outboxIDs(records []collab.OutboxRecord) []any {
+	ids := make([]any, len(records))
+	for i, r := range records {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -3,10 +3,12 @@ package ws
 import (
 	// "time"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"slices"
 	"strconv"
+	"sync"
 	"time"
 
 	"collabServer/backend/internal/collab"
@@ -15,19 +17,32 @@ import (
 )
 
 type Conn struct {
-	ws        *websocket.Conn
-	hub       *Hub
-	docID     string
-	userID    uint64
-	username  string
-	clientID  string
-	clientSeq uint64
+	ws       *websocket.Conn
+	hub      *Hub
+	docID    string
+	userID   uint64
+	username string
 	// chan是 Go 的“通道”（channel），是 goroutine 之间通信的队列。send chan ServerMessage 表示一个只能存放 ServerMessage 的队列。
 	send chan OutboundMessage
 	//协作引擎服务
 	svc collab.Service
 	// 信号量控制
 	sem *collab.SemaphoreControl
+
+	// 已提交、等待 op 监听器回 ack 的操作 -> 提交时的 baseRevision
+	acksMu      sync.Mutex
+	pendingAcks map[ackKey]uint64
+
+	// 连接关闭时 close，之后入队的消息直接丢弃。send 本身从不 close：
+	// op 监听器、跨实例投递和停机通知在其他 goroutine 里随时可能向它发送
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type ackKey struct {
+	docID     string
+	clientID  string
+	clientSeq uint64
 }
 
 // 出站消息接口
@@ -36,13 +51,18 @@ type OutboundMessage interface {
 }
 
 // 隐式实现（继承） OutboundMessage 接口
-func (m ServerMessage) MessageType() string      { return m.Type }
-func (m OpSubmitMessage) MessageType() string    { return m.Type }
-func (m OpAppliedMessage) MessageType() string   { return m.Type }
-func (m OpBroadcastMessage) MessageType() string { return m.Type }
+func (m ServerMessage) MessageType() string       { return m.Type }
+func (m OpSubmitMessage) MessageType() string     { return m.Type }
+func (m OpAppliedMessage) MessageType() string    { return m.Type }
+func (m OpBroadcastMessage) MessageType() string  { return m.Type }
+func (m SyncMessage) MessageType() string         { return m.Type }
+func (m SnapshotListMessage) MessageType() string { return m.Type }
+
+// 重连追平时增量下发的最大操作数，超过则改为下发全量内容
+const maxSyncOps = 500
 
 func NewConn(ws *websocket.Conn, hub *Hub, docID string, userID uint64, username string, svc collab.Service, sem *collab.SemaphoreControl) *Conn {
-	return &Conn{ws: ws, hub: hub, docID: docID, userID: userID, username: username, send: make(chan OutboundMessage, 32), svc: svc, sem: sem}
+	return &Conn{ws: ws, hub: hub, docID: docID, userID: userID, username: username, send: make(chan OutboundMessage, 32), svc: svc, sem: sem, done: make(chan struct{})}
 }
 
 func SetDocID(c *Conn, docID string) {
@@ -50,6 +70,9 @@ func SetDocID(c *Conn, docID string) {
 }
 
 func (c *Conn) SendMessage_Enqueue(msg OutboundMessage) {
+	if c.isClosed() {
+		return
+	}
 	// select 语句是 Go 的“多路复用”机制，用于同时监听多个通道操作，并选择其中一个执行。
 	// 同时评估所有 case 的通道操作
 	// 如果多个 case 都就绪，随机选择一个执行
@@ -60,6 +83,20 @@ func (c *Conn) SendMessage_Enqueue(msg OutboundMessage) {
 	}
 }
 
+// 标记连接已关闭：writeLoop 退出，之后的消息不再入队
+func (c *Conn) markClosed() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+func (c *Conn) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Conn) handleOpSubmit(ctx context.Context, msg OpSubmitMessage, authorID uint64) {
 	OpSubmitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
 	defer cancel()
@@ -70,19 +107,149 @@ func (c *Conn) handleOpSubmit(ctx context.Context, msg OpSubmitMessage, authorID
 	}
 	defer c.sem.Release()
 
-	_, err := c.svc.Submit(OpSubmitCtx, msg.DocID, authorID,
+	// 在房间内时 ack 由 op 监听器在广播该操作时一起下发，保证提交者先收到 revision 更小的广播再收到 ack；
+	// 不在该文档房间内的连接收不到广播，直接回 ack
+	key := ackKey{docID: msg.DocID, clientID: msg.ClientId, clientSeq: msg.ClientSeq}
+	viaListener := c.hub.inRoom(msg.DocID, c)
+	awaiting := viaListener && !c.expectAck(key, msg.BaseRevision)
+
+	applied, err := c.svc.Submit(OpSubmitCtx, msg.DocID, authorID,
 		msg.BaseRevision, msg.ClientId, msg.ClientSeq, msg.Ops)
+	if err != nil && viaListener && !awaiting {
+		c.takeAck(key)
+	}
+	if c.redirectIfNotOwner(err) {
+		return
+	}
+	var dup *collab.DuplicateOpError
+	if errors.As(err, &dup) {
+		if awaiting {
+			// 上一次提交的 ack 还在等 op 监听器下发
+			return
+		}
+		// ack 丢失后的重试：原样重发 ack，操作已经广播过，不再广播
+		c.SendMessage_Enqueue(OpAppliedMessage{Type: "op_applied", DocID: msg.DocID, BaseRevision: msg.BaseRevision, CurrentRevision: dup.Op.Revision,
+			OperationId: dup.Op.OperationId, ClientId: msg.ClientId, ClientSeq: msg.ClientSeq, Ops: dup.Op.Ops, AppliedAt: dup.Op.AppliedAt, Duplicate: true})
+		return
+	}
+	if errors.Is(err, collab.ErrBaseTooOld) {
+		// 本地版本落后太多：客户端应先 sync 追平，再基于新版本重新提交
+		c.SendMessage_Enqueue(ServerMessage{Type: "error", DocID: msg.DocID, Content: err.Error(), ClientId: msg.ClientId})
+		return
+	}
+	var outOfOrder *collab.OutOfOrderError
+	if errors.As(err, &outOfOrder) {
+		c.SendMessage_Enqueue(ServerMessage{Type: "error", DocID: msg.DocID, Content: "OUT_OF_ORDER", ClientId: outOfOrder.ClientId, ResendFromSeq: outOfOrder.ExpectedSeq})
+		return
+	}
 	if err != nil {
 		c.SendMessage_Enqueue(ServerMessage{Type: "error", Content: err.Error()})
 		return
 	}
-	curr_revision, _ := c.svc.CurrentRevision(ctx, msg.DocID)
-	c.SendMessage_Enqueue(OpAppliedMessage{Type: "op_applied", DocID: msg.DocID, BaseRevision: msg.BaseRevision, CurrentRevision: curr_revision, ClientId: msg.ClientId, ClientSeq: msg.ClientSeq})
-	c.hub.BroadcastAppliedOp(msg.DocID, c, c.userID, msg.Ops)
+	if viaListener {
+		return
+	}
+	c.SendMessage_Enqueue(OpAppliedMessage{Type: "op_applied", DocID: msg.DocID, BaseRevision: msg.BaseRevision, CurrentRevision: applied.Revision,
+		OperationId: applied.OperationId, ClientId: msg.ClientId, ClientSeq: msg.ClientSeq, Ops: applied.Ops, AppliedAt: applied.AppliedAt})
+}
+
+// 登记等待 op 监听器下发的 ack，已在等待时返回 false
+func (c *Conn) expectAck(key ackKey, baseRevision uint64) bool {
+	c.acksMu.Lock()
+	defer c.acksMu.Unlock()
+	if _, ok := c.pendingAcks[key]; ok {
+		return false
+	}
+	if c.pendingAcks == nil {
+		c.pendingAcks = make(map[ackKey]uint64)
+	}
+	c.pendingAcks[key] = baseRevision
+	return true
+}
+
+func (c *Conn) takeAck(key ackKey) (uint64, bool) {
+	c.acksMu.Lock()
+	defer c.acksMu.Unlock()
+	base, ok := c.pendingAcks[key]
+	delete(c.pendingAcks, key)
+	return base, ok
+}
+
+// 由 op 监听器或跨实例订阅按 revision 顺序调用：该连接提交的操作先回 ack，再推送广播。
+// 房间快照里的连接可能已经关闭，按连接自己的状态判断
+func (c *Conn) deliverOp(msg OpBroadcastMessage) {
+	if c.isClosed() {
+		return
+	}
+	if msg.ClientId != "" {
+		if base, ok := c.takeAck(ackKey{docID: msg.DocID, clientID: msg.ClientId, clientSeq: msg.ClientSeq}); ok {
+			c.SendMessage_Enqueue(OpAppliedMessage{Type: "op_applied", DocID: msg.DocID, BaseRevision: base, CurrentRevision: msg.Revision,
+				OperationId: msg.OperationId, ClientId: msg.ClientId, ClientSeq: msg.ClientSeq, Ops: msg.Ops, AppliedAt: msg.AppliedAt})
+		}
+	}
+	c.SendMessage_Enqueue(msg)
+}
+
+// 文档由其他实例持有时通知客户端重连到 owner（Content 为 owner 地址），返回是否已处理
+func (c *Conn) redirectIfNotOwner(err error) bool {
+	var notOwner *collab.NotOwnerError
+	if !errors.As(err, &notOwner) {
+		return false
+	}
+	c.SendMessage_Enqueue(ServerMessage{Type: "redirect", DocID: notOwner.DocID, Content: notOwner.Owner})
+	return true
+}
+
+// 把客户端从 lastKnown 追平到服务端最新版本
+func (c *Conn) handleSync(ctx context.Context, docID string, lastKnown uint64) {
+	current, err := c.svc.CurrentRevision(ctx, docID)
+	if c.redirectIfNotOwner(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("sync current revision error (doc=%s): %v", docID, err)
+		c.SendMessage_Enqueue(ServerMessage{Type: "error", DocID: docID, Content: "SYNC_FAILED"})
+		return
+	}
+	if lastKnown <= current && current-lastKnown <= maxSyncOps {
+		ops, err := c.svc.OpsSince(ctx, docID, lastKnown, maxSyncOps)
+		if err != nil {
+			log.Printf("sync ops since error (doc=%s from=%d): %v", docID, lastKnown, err)
+		} else if synced, rev, ok := continuousOps(ops, lastKnown, current); ok {
+			c.SendMessage_Enqueue(SyncMessage{Type: "sync", DocID: docID, FromRevision: lastKnown, Revision: rev, Ops: synced})
+			return
+		}
+	}
+
+	// 差距过大、客户端版本超前或历史不连续：下发全量
+	doc, rev, err := c.svc.LoadDocumentDelta(ctx, docID)
+	if err != nil {
+		log.Printf("sync load document error (doc=%s): %v", docID, err)
+		c.SendMessage_Enqueue(ServerMessage{Type: "error", DocID: docID, Content: "SYNC_FAILED"})
+		return
+	}
+	c.SendMessage_Enqueue(SyncMessage{Type: "sync", DocID: docID, FromRevision: lastKnown, Revision: rev, Full: true, Content: doc.Text(), Doc: doc})
+}
+
+// 校验 ops 从 from+1 开始连续且至少追到 current，返回下发内容和追平后的版本
+func continuousOps(ops []collab.AppliedOp, from, current uint64) ([]SyncedOp, uint64, bool) {
+	rev := from
+	out := make([]SyncedOp, 0, len(ops))
+	for _, op := range ops {
+		if op.Revision != rev+1 {
+			return nil, 0, false
+		}
+		rev = op.Revision
+		out = append(out, SyncedOp{Revision: op.Revision, OperationId: op.OperationId, AuthorID: op.AuthorId,
+			ClientId: op.ClientId, ClientSeq: op.ClientSeq, Ops: op.Ops, AppliedAt: op.AppliedAt})
+	}
+	if rev < current {
+		return nil, 0, false
+	}
+	return out, rev, true
 }
 
 func (c *Conn) readLoop(ctx context.Context) {
-	defer close(c.send)
 	for {
 		var clientMessage ClientMessage
 		if err := c.ws.ReadJSON(&clientMessage); err != nil {
@@ -112,17 +279,12 @@ func (c *Conn) readLoop(ctx context.Context) {
 			docTitle := clientMessage.DocTitle
 			log.Printf("username: %s", c.username)
 			log.Printf("docTitle: %s", docTitle)
-			if err := c.svc.CreateDocument(ctx, c.userID, docTitle); err != nil {
+			docID, err := c.svc.CreateDocument(ctx, c.userID, docTitle)
+			if err != nil {
 				log.Printf("create document error: %v", err)
 				c.send <- ServerMessage{Type: "error", Content: "CREATE_DOC_FAILED"}
 				return
 			}
-			docID, err := c.svc.GetDocumentID(ctx, docTitle)
-			if err != nil {
-				log.Printf("get document id error: %v", err)
-				c.send <- ServerMessage{Type: "error", Content: "GET_DOCID_FAILED"}
-				return
-			}
 			c.hub.presence.AddMember(ctx, docID, c.userID, c.username, 600*time.Second)
 			c.send <- ServerMessage{Type: "createDocument", DocID: docID, Content: "Document " + docID + " created by user " + strconv.FormatUint(c.userID, 10)}
 
@@ -157,6 +319,21 @@ func (c *Conn) readLoop(ctx context.Context) {
 			c.hub.Join(c.docID, c)
 			c.hub.presence.AddMember(ctx, c.docID, c.userID, c.username, 600*time.Second)
 			c.send <- ServerMessage{Type: "joinDocument", DocID: c.docID, Content: "Document " + c.docID + " joined by user " + strconv.FormatUint(c.userID, 10)}
+			// 重连的标签页带上本地版本，加入房间后立即追平
+			if clientMessage.LastKnownRevision != nil {
+				c.handleSync(ctx, c.docID, *clientMessage.LastKnownRevision)
+			}
+
+		case "sync":
+			docID := clientMessage.DocID
+			if docID == "" {
+				docID = c.docID
+			}
+			if docID == "" || clientMessage.LastKnownRevision == nil {
+				c.send <- ServerMessage{Type: "error", Content: "SYNC_MISSING_DOC_OR_REVISION"}
+				continue
+			}
+			c.handleSync(ctx, docID, *clientMessage.LastKnownRevision)
 
 		case "show_alive_members":
 			// []cache.PresenceMember
@@ -191,12 +368,46 @@ func (c *Conn) readLoop(ctx context.Context) {
 			}
 			c.send <- ServerMessage{Type: "saveDocument", Content: "Document " + clientMessage.DocID + " saved"}
 
+		case "listSnapshots":
+			snaps, err := c.svc.ListSnapshots(ctx, clientMessage.DocID, clientMessage.Limit)
+			if err != nil {
+				log.Printf("list snapshots error: %v", err)
+				c.send <- ServerMessage{Type: "error", DocID: clientMessage.DocID, Content: "LIST_SNAPSHOTS_FAILED"}
+				continue
+			}
+			c.send <- SnapshotListMessage{Type: "listSnapshots", DocID: clientMessage.DocID, Snapshots: snaps}
+
+		case "getSnapshot":
+			content, err := c.svc.GetSnapshot(ctx, clientMessage.DocID, clientMessage.Revision)
+			if err != nil {
+				log.Printf("get snapshot error: %v", err)
+				c.send <- ServerMessage{Type: "error", DocID: clientMessage.DocID, Content: err.Error()}
+				continue
+			}
+			c.send <- ServerMessage{Type: "getSnapshot", DocID: clientMessage.DocID, Revision: clientMessage.Revision, Content: content}
+
+		case "restoreSnapshot":
+			applied, err := c.svc.RestoreSnapshot(ctx, clientMessage.DocID, c.userID, clientMessage.Revision)
+			if err != nil {
+				log.Printf("restore snapshot error: %v", err)
+				c.send <- ServerMessage{Type: "error", DocID: clientMessage.DocID, Content: err.Error()}
+				continue
+			}
+			c.send <- ServerMessage{Type: "restoreSnapshot", DocID: clientMessage.DocID, Revision: applied.Revision,
+				Content: "Document " + clientMessage.DocID + " restored to snapshot " + strconv.FormatUint(clientMessage.Revision, 10)}
+			// 回滚是一次普通的新版本，所有人（包括发起者）都会按 op_broadcast 收到并应用
+
 		case "loadDocumentContent":
-			content, revision, err := c.svc.LoadDocumentContent(ctx, clientMessage.DocID)
+			// 一次读取带样式的文档与版本，纯文本由它得出，保证两者属于同一版本
+			ops, revision, err := c.svc.LoadDocumentDelta(ctx, clientMessage.DocID)
+			if c.redirectIfNotOwner(err) {
+				break
+			}
 			if err != nil {
 				log.Printf("load document content error: %v", err)
 			} else {
-				c.send <- ServerMessage{Type: "loadDocumentContent", Content: content, Revision: revision}
+				// 纯文本之外附带样式信息，旧客户端只读 content 不受影响
+				c.send <- ServerMessage{Type: "loadDocumentContent", Content: ops.Text(), Revision: revision, Ops: ops}
 			}
 
 		default:
@@ -207,8 +418,13 @@ func (c *Conn) readLoop(ctx context.Context) {
 }
 
 func (c *Conn) writeLoop() {
-	// 持续消费通道中的ServerMessage
-	for msg := range c.send {
-		_ = c.ws.WriteJSON(msg)
+	// 持续消费通道中的ServerMessage，连接关闭后退出
+	for {
+		select {
+		case msg := <-c.send:
+			_ = c.ws.WriteJSON(msg)
+		case <-c.done:
+			return
+		}
 	}
 }
@@ -0,0 +1,125 @@
+package ws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"collabServer/backend/internal/cache"
+)
+
+// 跨实例广播的消息类型
+const (
+	envelopeOp       = "op"
+	envelopePresence = "presence"
+)
+
+// 发布到房间频道的消息，Origin 为发布者实例 ID
+type roomEnvelope struct {
+	Origin  string              `json:"origin"`
+	Kind    string              `json:"kind"`
+	DocID   string              `json:"docId"`
+	Op      *OpBroadcastMessage `json:"op,omitempty"`
+	Members []PresenceMember    `json:"members,omitempty"`
+}
+
+const (
+	// 发布在广播调用方的 goroutine 里同步完成，保证同一实例发出的消息顺序
+	publishTimeout   = 200 * time.Millisecond
+	subscribeTimeout = 2 * time.Second
+)
+
+// EnableFanout 启用跨实例广播：本地广播同时发布到 bus，其他实例发布的消息投递给本地连接。
+// instanceID 为空时随机生成一个。需要在接受连接之前调用。
+func (h *Hub) EnableFanout(bus cache.RoomBus, instanceID string) {
+	if instanceID == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		instanceID = hex.EncodeToString(b)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bus = bus
+	h.instanceID = instanceID
+}
+
+func (h *Hub) publish(env roomEnvelope) {
+	if h.bus == nil {
+		return
+	}
+	env.Origin = h.instanceID
+	payload, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("marshal room envelope error (doc=%s): %v", env.DocID, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := h.bus.Publish(ctx, env.DocID, payload); err != nil {
+		log.Printf("publish room message error (doc=%s kind=%s): %v", env.DocID, env.Kind, err)
+	}
+}
+
+// 其他实例发布的消息：只投递给本地连接，不再转发
+func (h *Hub) handleRemote(payload []byte) {
+	var env roomEnvelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		log.Printf("unmarshal room envelope error: %v", err)
+		return
+	}
+	if env.Origin == h.instanceID {
+		// 自己发布的消息，发布时已经投递过本地连接
+		return
+	}
+	switch env.Kind {
+	case envelopeOp:
+		if env.Op != nil {
+			h.deliverOp(env.DocID, *env.Op)
+		}
+	case envelopePresence:
+		h.deliverPresence(env.DocID, env.Members)
+	}
+}
+
+// 在后台让 docID 的频道订阅与本地房间状态一致，Join / Leave 不等待 Redis
+func (h *Hub) requestSubscriptionSync(docID string) {
+	if h.bus == nil {
+		return
+	}
+	go h.syncSubscription(docID)
+}
+
+// 让 docID 的频道订阅与本地房间状态一致：房间有连接时订阅，空了取消订阅。
+// Join/Leave 并发时串行在 subsMu 上，以当时的房间状态为准，最终总会收敛。
+func (h *Hub) syncSubscription(docID string) {
+	if h.bus == nil {
+		return
+	}
+	h.subsMu.Lock()
+	defer h.subsMu.Unlock()
+
+	h.mu.RLock()
+	active := len(h.rooms[docID]) > 0
+	h.mu.RUnlock()
+
+	unsubscribe, subscribed := h.subs[docID]
+	switch {
+	case active && !subscribed:
+		ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+		defer cancel()
+		unsubscribe, err := h.bus.Subscribe(ctx, docID, h.handleRemote)
+		if err != nil {
+			log.Printf("subscribe room channel error (doc=%s): %v", docID, err)
+			return
+		}
+		h.subs[docID] = unsubscribe
+	case !active && subscribed:
+		delete(h.subs, docID)
+		if err := unsubscribe(); err != nil {
+			log.Printf("unsubscribe room channel error (doc=%s): %v", docID, err)
+		}
+	}
+}
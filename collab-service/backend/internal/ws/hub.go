@@ -5,7 +5,7 @@ import (
 	"sync"
 
 	"collabServer/backend/internal/cache"
-	"collabServer/backend/internal/ot/delta"
+	"collabServer/backend/internal/collab"
 )
 
 type Hub struct {
@@ -17,62 +17,145 @@ type Hub struct {
 	mu sync.RWMutex
 	// docID -> set of connections
 	rooms map[string]map[*Conn]struct{}
+	// 房间最后一个连接离开时回调（例如触发快照），在锁外调用
+	onRoomEmpty func(docID string)
+	// 用户在房间内的第一个连接加入 / 最后一个连接离开时回调（例如发出成员事件），在锁外调用
+	onMembership func(docID string, userID uint64, username string, joined bool)
+
+	// 跨实例广播（nil 表示只广播本地连接）。本地有连接的房间才订阅频道，
+	// instanceID 用来丢弃自己发布、又从频道收回来的消息。
+	bus        cache.RoomBus
+	instanceID string
+	subsMu     sync.Mutex
+	subs       map[string]func() error
 }
 
 func NewHub(p cache.PresenceCache) *Hub {
-	return &Hub{presence: p, rooms: make(map[string]map[*Conn]struct{})}
+	return &Hub{presence: p, rooms: make(map[string]map[*Conn]struct{}), subs: make(map[string]func() error)}
 }
 
 // Join 将连接加入指定文档房间
 func (h *Hub) Join(docID string, c *Conn) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 	if h.rooms[docID] == nil {
 		// 为什么房间里存的是 map[Conn]，而不是 map[userID]
 		// - 一个用户可开多个标签页/设备（多连接）；广播要逐连接发，不能只按 userID 发一次。
 		h.rooms[docID] = make(map[*Conn]struct{})
 	}
+	_, rejoin := h.rooms[docID][c]
+	firstConn := !rejoin && !h.hasUserLocked(docID, c.userID)
 	h.rooms[docID][c] = struct{}{}
+	onMembership := h.onMembership
+	h.mu.Unlock()
+
+	// 订阅在后台进行，不阻塞加入；之前订阅失败的话这里会重试
+	h.requestSubscriptionSync(docID)
+	if firstConn && onMembership != nil {
+		onMembership(docID, c.userID, c.username, true)
+	}
+}
+
+// 房间内是否还有该用户的连接，调用方持有 h.mu
+func (h *Hub) hasUserLocked(docID string, userID uint64) bool {
+	for conn := range h.rooms[docID] {
+		if conn.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// OnMembership 注册成员加入 / 离开的回调（按用户而不是按连接：多个标签页只算一次）
+func (h *Hub) OnMembership(fn func(docID string, userID uint64, username string, joined bool)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onMembership = fn
+}
+
+// OnRoomEmpty 注册房间清空时的回调
+func (h *Hub) OnRoomEmpty(fn func(docID string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onRoomEmpty = fn
 }
 
 // Leave 将连接从指定文档房间移除
 func (h *Hub) Leave(docID string, c *Conn) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	empty, lastConn := false, false
 	if conns, ok := h.rooms[docID]; ok {
-		delete(conns, c)
+		if _, member := conns[c]; member {
+			delete(conns, c)
+			lastConn = !h.hasUserLocked(docID, c.userID)
+		}
 		if len(conns) == 0 {
 			delete(h.rooms, docID)
+			empty = true
+		}
+	}
+	onRoomEmpty := h.onRoomEmpty
+	onMembership := h.onMembership
+	h.mu.Unlock()
+
+	if lastConn && onMembership != nil {
+		onMembership(docID, c.userID, c.username, false)
+	}
+
+	if empty {
+		h.requestSubscriptionSync(docID)
+		if onRoomEmpty != nil {
+			onRoomEmpty(docID)
 		}
 	}
 }
 
-func (h *Hub) BroadcastPresence(docID string, members []PresenceMember) {
+// 房间内连接的快照，广播时在锁外逐个发送
+func (h *Hub) roomConns(docID string) []*Conn {
 	h.mu.RLock()
-	conns := h.rooms[docID]
-	h.mu.RUnlock()
+	defer h.mu.RUnlock()
+	conns := make([]*Conn, 0, len(h.rooms[docID]))
+	for c := range h.rooms[docID] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+func (h *Hub) BroadcastPresence(docID string, members []PresenceMember) {
+	h.deliverPresence(docID, members)
+	h.publish(roomEnvelope{Kind: envelopePresence, DocID: docID, Members: members})
+}
+
+func (h *Hub) deliverPresence(docID string, members []PresenceMember) {
 	content, err := json.Marshal(members)
 	if err != nil {
 		return
 	}
 	msg := ServerMessage{Type: "presence", DocID: docID, Content: string(content)}
 	msg.Members = members
-	for c := range conns {
+	for _, c := range h.roomConns(docID) {
 		c.SendMessage_Enqueue(msg)
 	}
 }
 
-func (h *Hub) BroadcastAppliedOp(docID string, sender *Conn, userID uint64, rng interface{}) {
-	h.mu.RLock()
-	conns := h.rooms[docID]
-	h.mu.RUnlock()
-	if rng, ok := rng.(delta.Delta); ok {
-		msg := OpBroadcastMessage{Type: "op_broadcast", DocID: docID, AuthorID: sender.userID, ClientId: sender.clientID, ClientSeq: sender.clientSeq, Ops: rng}
-		for c := range conns {
-			if c == sender {
-				continue
-			}
-			c.SendMessage_Enqueue(msg)
-		}
+// BroadcastOp 把服务端已应用的操作推送给房间内的所有连接（包括提交者自己），提交者在广播之前先收到 ack。
+// 作为协作引擎的 op 监听器调用：同一文档按 revision 顺序、在同一个 goroutine 里调用，
+// 本地投递与跨实例发布都是同步的，因此每个连接收到的 revision 严格递增，客户端据此发现缺口。
+func (h *Hub) BroadcastOp(docID string, op collab.AppliedOp) {
+	msg := OpBroadcastMessage{Type: "op_broadcast", DocID: docID, Revision: op.Revision, OperationId: op.OperationId, AuthorID: op.AuthorId,
+		ClientId: op.ClientId, ClientSeq: op.ClientSeq, Ops: op.Ops, AppliedAt: op.AppliedAt}
+	h.deliverOp(docID, msg)
+	h.publish(roomEnvelope{Kind: envelopeOp, DocID: docID, Op: &msg})
+}
+
+func (h *Hub) deliverOp(docID string, msg OpBroadcastMessage) {
+	for _, c := range h.roomConns(docID) {
+		c.deliverOp(msg)
 	}
 }
+
+func (h *Hub) inRoom(docID string, c *Conn) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.rooms[docID][c]
+	return ok
+}
@@ -0,0 +1,286 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"collabServer/backend/internal/collab"
+	"collabServer/backend/internal/ot/delta"
+)
+
+// 内存版 RoomBus：同步把消息投递给所有订阅者（包括发布者自己）
+type memoryBus struct {
+	mu   sync.Mutex
+	subs map[string][]func([]byte)
+}
+
+func (b *memoryBus) Publish(ctx context.Context, docID string, payload []byte) error {
+	b.mu.Lock()
+	handlers := append([]func([]byte){}, b.subs[docID]...)
+	b.mu.Unlock()
+	for _, h := range handlers {
+		h(payload)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(ctx context.Context, docID string, handler func([]byte)) (func() error, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs == nil {
+		b.subs = make(map[string][]func([]byte))
+	}
+	b.subs[docID] = append(b.subs[docID], handler)
+	idx := len(b.subs[docID]) - 1
+	return func() error {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.subs[docID][idx] = func([]byte) {}
+		return nil
+	}, nil
+}
+
+func receive(t *testing.T, c *Conn) OutboundMessage {
+	t.Helper()
+	select {
+	case msg := <-c.send:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func assertNoMessage(t *testing.T, c *Conn) {
+	t.Helper()
+	select {
+	case msg := <-c.send:
+		t.Fatalf("unexpected message %+v", msg)
+	default:
+	}
+}
+
+// 订阅在后台进行，等 hub 对 docID 的订阅状态变为 want
+func waitSubscribed(t *testing.T, h *Hub, docID string, want bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		h.subsMu.Lock()
+		_, ok := h.subs[docID]
+		h.subsMu.Unlock()
+		if ok == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("subscribed(%s) = %v, want %v", docID, ok, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHub_FanoutAcrossInstances(t *testing.T) {
+	bus := &memoryBus{}
+	hubA, hubB := NewHub(nil), NewHub(nil)
+	hubA.EnableFanout(bus, "a")
+	hubB.EnableFanout(bus, "b")
+
+	sender := NewConn(nil, hubA, "doc", 1, "alice", nil, nil)
+	localPeer := NewConn(nil, hubA, "doc", 2, "bob", nil, nil)
+	remotePeer := NewConn(nil, hubB, "doc", 3, "carol", nil, nil)
+	hubA.Join("doc", sender)
+	hubA.Join("doc", localPeer)
+	hubB.Join("doc", remotePeer)
+	waitSubscribed(t, hubA, "doc", true)
+	waitSubscribed(t, hubB, "doc", true)
+
+	op := collab.AppliedOp{Revision: 7, AuthorId: 1, ClientId: "c1", ClientSeq: 3, Ops: delta.Delta{{Kind: delta.KindInsert, Text: "x"}}}
+	hubA.BroadcastOp("doc", op)
+
+	// 本地（包括提交者）与远端连接各收到一次，本实例不会因为频道回流而重复收到
+	for _, c := range []*Conn{sender, localPeer, remotePeer} {
+		msg, ok := receive(t, c).(OpBroadcastMessage)
+		if !ok || msg.Revision != 7 || msg.ClientId != "c1" {
+			t.Fatalf("user %d got %+v, want op_broadcast rev 7", c.userID, msg)
+		}
+		assertNoMessage(t, c)
+	}
+
+	// 远端房间清空后取消订阅，不再收到消息
+	hubB.Leave("doc", remotePeer)
+	waitSubscribed(t, hubB, "doc", false)
+	hubA.BroadcastPresence("doc", []PresenceMember{{UserID: 1}})
+	if msg, ok := receive(t, localPeer).(ServerMessage); !ok || msg.Type != "presence" {
+		t.Fatalf("local peer got %+v, want presence", msg)
+	}
+	assertNoMessage(t, remotePeer)
+}
+
+func TestHub_MembershipEventsPerUser(t *testing.T) {
+	hub := NewHub(nil)
+	var events []string
+	hub.OnMembership(func(docID string, userID uint64, username string, joined bool) {
+		kind := "left"
+		if joined {
+			kind = "joined"
+		}
+		events = append(events, username+" "+kind)
+	})
+
+	// alice 开了两个标签页：第一个加入、最后一个离开时各触发一次
+	tab1 := NewConn(nil, hub, "doc", 1, "alice", nil, nil)
+	tab2 := NewConn(nil, hub, "doc", 1, "alice", nil, nil)
+	hub.Join("doc", tab1)
+	hub.Join("doc", tab2)
+	hub.Leave("doc", tab1)
+	hub.Leave("doc", tab2)
+	hub.Leave("doc", tab2)
+
+	if len(events) != 2 || events[0] != "alice joined" || events[1] != "alice left" {
+		t.Fatalf("membership events = %v, want [alice joined, alice left]", events)
+	}
+}
+
+func TestHub_BroadcastsInRevisionOrder(t *testing.T) {
+	bus := &memoryBus{}
+	hubA, hubB := NewHub(nil), NewHub(nil)
+	hubA.EnableFanout(bus, "a")
+	hubB.EnableFanout(bus, "b")
+	svc := collab.NewInMemoryService(nil, nil, nil, nil, collab.WithOpListener(hubA.BroadcastOp))
+
+	local := NewConn(nil, hubA, "doc", 1, "alice", svc, nil)
+	remote := NewConn(nil, hubB, "doc", 2, "bob", nil, nil)
+	local.send = make(chan OutboundMessage, 64)
+	remote.send = make(chan OutboundMessage, 64)
+	hubA.Join("doc", local)
+	hubB.Join("doc", remote)
+	waitSubscribed(t, hubA, "doc", true)
+	waitSubscribed(t, hubB, "doc", true)
+
+	// 多个客户端并发提交，每个连接都应按 revision 1..n 依次收到
+	const clients, perClient = 4, 8
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for seq := uint64(1); seq <= perClient; seq++ {
+				ops := delta.Delta{{Kind: delta.KindInsert, Text: "x"}}
+				if _, err := svc.Submit(context.Background(), "doc", 1, 0, fmt.Sprintf("c%d", i), seq, ops); err != nil {
+					t.Errorf("Submit() error = %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, c := range []*Conn{local, remote} {
+		for want := uint64(1); want <= clients*perClient; want++ {
+			msg, ok := receive(t, c).(OpBroadcastMessage)
+			if !ok || msg.Revision != want || msg.OperationId == "" || msg.AppliedAt.IsZero() {
+				t.Fatalf("user %d got %+v, want op_broadcast rev %d", c.userID, msg, want)
+			}
+		}
+	}
+}
+
+func TestHub_AckFollowsEarlierBroadcasts(t *testing.T) {
+	hub := NewHub(nil)
+	svc := collab.NewInMemoryService(nil, nil, nil, nil, collab.WithOpListener(hub.BroadcastOp))
+	sem := collab.NewSemaphoreControl()
+
+	const perConn = 10
+	conns := []*Conn{
+		NewConn(nil, hub, "doc", 1, "alice", svc, sem),
+		NewConn(nil, hub, "doc", 2, "bob", svc, sem),
+	}
+	for _, c := range conns {
+		c.send = make(chan OutboundMessage, 4*perConn)
+		hub.Join("doc", c)
+	}
+
+	// 两个连接交替并发提交，每个连接收到 revision N 的 ack 之前必须已经收到 N-1 及之前的全部广播
+	var wg sync.WaitGroup
+	for i, c := range conns {
+		wg.Add(1)
+		go func(i int, c *Conn) {
+			defer wg.Done()
+			for seq := uint64(1); seq <= perConn; seq++ {
+				c.handleOpSubmit(context.Background(), OpSubmitMessage{Type: "op_submit", DocID: "doc", BaseRevision: 0,
+					ClientId: fmt.Sprintf("c%d", i), ClientSeq: seq, Ops: delta.Delta{{Kind: delta.KindInsert, Text: "x"}}}, c.userID)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	for i, c := range conns {
+		var lastBroadcast uint64
+		acks := 0
+		for lastBroadcast < 2*perConn {
+			switch msg := receive(t, c).(type) {
+			case OpAppliedMessage:
+				if msg.CurrentRevision != lastBroadcast+1 || msg.ClientId != fmt.Sprintf("c%d", i) {
+					t.Fatalf("conn %d: ack %+v after broadcast rev %d, want rev %d", i, msg, lastBroadcast, lastBroadcast+1)
+				}
+				acks++
+			case OpBroadcastMessage:
+				if msg.Revision != lastBroadcast+1 {
+					t.Fatalf("conn %d: broadcast rev %d after %d", i, msg.Revision, lastBroadcast)
+				}
+				lastBroadcast = msg.Revision
+			default:
+				t.Fatalf("conn %d: unexpected message %+v", i, msg)
+			}
+		}
+		if acks != perConn {
+			t.Fatalf("conn %d: got %d acks, want %d", i, acks, perConn)
+		}
+	}
+}
+
+func TestHub_ClosedConnDropsBroadcasts(t *testing.T) {
+	hub := NewHub(nil)
+	open := NewConn(nil, hub, "doc", 1, "alice", nil, nil)
+	closed := NewConn(nil, hub, "doc", 2, "bob", nil, nil)
+	hub.Join("doc", open)
+	hub.Join("doc", closed)
+
+	// 连接已关闭但还没离开房间：监听器的广播不能 panic，也不再入队
+	closed.markClosed()
+	closed.markClosed()
+	hub.BroadcastOp("doc", collab.AppliedOp{Revision: 1, Ops: delta.Delta{{Kind: delta.KindInsert, Text: "x"}}})
+
+	if msg, ok := receive(t, open).(OpBroadcastMessage); !ok || msg.Revision != 1 {
+		t.Fatalf("open conn got %+v, want op_broadcast rev 1", msg)
+	}
+	assertNoMessage(t, closed)
+}
+
+func TestHub_RemoteDeliverySkipsClosedConn(t *testing.T) {
+	bus := &memoryBus{}
+	hubA, hubB := NewHub(nil), NewHub(nil)
+	hubA.EnableFanout(bus, "a")
+	hubB.EnableFanout(bus, "b")
+
+	peer := NewConn(nil, hubB, "doc", 2, "bob", nil, nil)
+	closing := NewConn(nil, hubB, "doc", 3, "carol", nil, nil)
+	hubB.Join("doc", peer)
+	hubB.Join("doc", closing)
+	waitSubscribed(t, hubB, "doc", true)
+
+	// Redis 分发 goroutine 投递时连接已经关闭、还没 Leave
+	closing.markClosed()
+	hubA.BroadcastOp("doc", collab.AppliedOp{Revision: 1, Ops: delta.Delta{{Kind: delta.KindInsert, Text: "x"}}})
+	hubA.BroadcastPresence("doc", []PresenceMember{{UserID: 1}})
+
+	if msg, ok := receive(t, peer).(OpBroadcastMessage); !ok || msg.Revision != 1 {
+		t.Fatalf("peer got %+v, want op_broadcast rev 1", msg)
+	}
+	if msg, ok := receive(t, peer).(ServerMessage); !ok || msg.Type != "presence" {
+		t.Fatalf("peer got %+v, want presence", msg)
+	}
+	assertNoMessage(t, closing)
+}
@@ -3,6 +3,7 @@ package ws
 import (
 	"time"
 
+	"collabServer/backend/internal/collab"
 	"collabServer/backend/internal/ot/delta"
 )
 
@@ -16,6 +17,11 @@ type ClientMessage struct {
 	ClientSeq    uint64      `json:"clientSeq"`
 	Ops          delta.Delta `json:"ops"`
 	Content      string      `json:"content,omitempty"`
+	// 快照相关请求（getSnapshot / restoreSnapshot）的目标版本，listSnapshots 的条数上限
+	Revision uint64 `json:"revision,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	// 客户端本地已知的最新版本，用于 sync / joinDocument 重连追平；nil 表示不追平
+	LastKnownRevision *uint64 `json:"lastKnownRevision,omitempty"`
 }
 
 type PresenceMember struct {
@@ -32,6 +38,13 @@ type ServerMessage struct {
 	Cursor   interface{}      `json:"cursor,omitempty"`
 	Range    interface{}      `json:"range,omitempty"`
 	Content  string           `json:"content,omitempty"`
+	// 带样式的文档内容（loadDocumentContent 时返回）
+	Ops delta.Delta `json:"ops,omitempty"`
+	// server_shutdown 时建议客户端等待多久后重连（毫秒）
+	ReconnectAfterMs int64 `json:"reconnectAfterMs,omitempty"`
+	// OUT_OF_ORDER 时客户端需要从该 clientSeq 开始按顺序重发
+	ClientId      string `json:"clientId,omitempty"`
+	ResendFromSeq uint64 `json:"resendFromSeq,omitempty"`
 }
 
 type OpSubmitMessage struct {
@@ -46,25 +59,67 @@ type OpSubmitMessage struct {
 	Ops       delta.Delta `json:"ops"`
 }
 
-// 广播给同文档房间内其他连接的“已应用操作”事件
-// - 与 op_applied(ack) 区分：这里用于把变更推送给其他协作者（包括同用户的其他标签页）
+// 广播给同文档房间内所有连接的“已应用操作”事件
+// - 与 op_applied(ack) 区分：这里用于把变更推送给所有协作者（包括同用户的其他标签页）
+// - 每个连接按 revision 严格递增的顺序收到，revision 不连续说明中间有消息丢失，应发 sync 追平
+// - 提交者也会收到自己的操作（ClientId/ClientSeq 与本地待确认操作一致），不要重复应用
 // - 前端可按需实现：收到后在本地应用 ops，并将本地 revision 对齐到 revision
 type OpBroadcastMessage struct {
-	Type      string      `json:"type"` // 固定 "op_broadcast"
-	DocID     string      `json:"docId"`
-	Revision  uint64      `json:"revision"` // 服务端已应用后的最新版本
-	AuthorID  uint64      `json:"authorId"`
-	ClientId  string      `json:"clientId,omitempty"`
-	ClientSeq uint64      `json:"clientSeq,omitempty"`
-	Ops       delta.Delta `json:"ops"`
-	AppliedAt time.Time   `json:"appliedAt,omitempty"`
+	Type     string `json:"type"` // 固定 "op_broadcast"
+	DocID    string `json:"docId"`
+	Revision uint64 `json:"revision"` // 该操作应用后的版本
+	// 操作的全局唯一 ID，客户端可据此去重（例如同时经 sync 与广播收到同一操作）
+	OperationId string      `json:"operationId,omitempty"`
+	AuthorID    uint64      `json:"authorId"`
+	ClientId    string      `json:"clientId,omitempty"`
+	ClientSeq   uint64      `json:"clientSeq,omitempty"`
+	Ops         delta.Delta `json:"ops"`
+	AppliedAt   time.Time   `json:"appliedAt,omitempty"`
 }
 
+// 提交者收到的 ack：与广播走同一条有序通道，收到 revision N 的 ack 时 N 之前的广播都已收到
 type OpAppliedMessage struct {
 	Type            string `json:"type"` // 固定 "op_applied"
 	DocID           string `json:"docId"`
 	BaseRevision    uint64 `json:"baseRevision"`    // 客户端提交时的 base
-	CurrentRevision uint64 `json:"currentRevision"` // 服务端应用后的最新版本
+	CurrentRevision uint64 `json:"currentRevision"` // 该操作应用后的版本
+	OperationId     string `json:"operationId"`     // 该操作的全局唯一 ID
 	ClientId        string `json:"clientId"`
 	ClientSeq       uint64 `json:"clientSeq"`
+	// 服务端实际应用的 ops（baseRevision 落后时为变换后的结果），客户端据此对齐本地状态
+	Ops       delta.Delta `json:"ops,omitempty"`
+	AppliedAt time.Time   `json:"appliedAt"`
+	// 重复提交时为 true：该操作之前已经应用过，这里是原 ack 的重发
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// 快照历史列表（按版本倒序）
+type SnapshotListMessage struct {
+	Type      string                `json:"type"` // 固定 "listSnapshots"
+	DocID     string                `json:"docId"`
+	Snapshots []collab.SnapshotInfo `json:"snapshots"`
+}
+
+// 重连追平的回复
+// - 增量模式：Ops 为 fromRevision 之后按版本升序的全部操作，客户端依次应用即可
+// - 全量模式（Full=true）：差距过大或历史操作不可用时，直接下发当前完整内容
+type SyncMessage struct {
+	Type         string      `json:"type"` // 固定 "sync"
+	DocID        string      `json:"docId"`
+	FromRevision uint64      `json:"fromRevision"`
+	Revision     uint64      `json:"revision"` // 追平之后客户端应处于的版本
+	Ops          []SyncedOp  `json:"ops,omitempty"`
+	Full         bool        `json:"full,omitempty"`
+	Content      string      `json:"content,omitempty"`
+	Doc          delta.Delta `json:"doc,omitempty"` // 全量模式下带样式的文档
+}
+
+type SyncedOp struct {
+	Revision    uint64      `json:"revision"`
+	OperationId string      `json:"operationId"`
+	AuthorID    uint64      `json:"authorId"`
+	ClientId    string      `json:"clientId,omitempty"`
+	ClientSeq   uint64      `json:"clientSeq,omitempty"`
+	Ops         delta.Delta `json:"ops"`
+	AppliedAt   time.Time   `json:"appliedAt"`
 }
@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func (m *Manager) isShuttingDown() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.shuttingDown
+}
+
+// 登记新连接；已经开始停机时返回 false，调用方直接断开
+func (m *Manager) register(c *Conn) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.shuttingDown {
+		return false
+	}
+	m.conns[c] = struct{}{}
+	return true
+}
+
+func (m *Manager) unregister(c *Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conns, c)
+}
+
+// Shutdown 拒绝新的连接，向所有现有连接发送 server_shutdown（附带建议的重连等待时间），
+// 在 ctx 期限内等待消息写出后关闭连接。返回通知的连接数。
+func (m *Manager) Shutdown(ctx context.Context, reconnectAfter time.Duration) int {
+	m.mu.Lock()
+	m.shuttingDown = true
+	conns := make([]*Conn, 0, len(m.conns))
+	for c := range m.conns {
+		// 正在断开的连接写循环已经退出，既收不到通知也不会清空发送队列
+		if !c.isClosed() {
+			conns = append(conns, c)
+		}
+	}
+	m.mu.Unlock()
+
+	msg := ServerMessage{Type: "server_shutdown", Content: "server is shutting down, please reconnect",
+		ReconnectAfterMs: reconnectAfter.Milliseconds()}
+	for _, c := range conns {
+		c.SendMessage_Enqueue(msg)
+	}
+
+	// 等各连接的发送队列清空（通知已交给 writeLoop），最多到 ctx 期限
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for pending := conns; len(pending) > 0; {
+		next := pending[:0]
+		for _, c := range pending {
+			if len(c.send) > 0 {
+				next = append(next, c)
+			}
+		}
+		pending = next
+		if len(pending) == 0 {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Printf("shutdown: %d connections still had queued messages", len(pending))
+			pending = nil
+		}
+	}
+
+	// 关闭连接：readLoop 随之退出，连接离开房间
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+	for _, c := range conns {
+		_ = c.ws.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		_ = c.ws.Close()
+	}
+	return len(conns)
+}
@@ -0,0 +1,31 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManager_ShutdownSkipsClosedConns(t *testing.T) {
+	m := NewManager(NewHub(nil), nil, nil)
+	closing := NewConn(nil, m.h, "", 1, "alice", nil, nil)
+	if !m.register(closing) {
+		t.Fatal("register() = false before shutdown")
+	}
+	// readLoop 已经退出、还没注销的连接
+	closing.markClosed()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if n := m.Shutdown(ctx, time.Second); n != 0 {
+		t.Fatalf("Shutdown() notified %d conns, want 0", n)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("Shutdown() waited on a closed conn's send queue")
+	}
+	assertNoMessage(t, closing)
+	if m.register(NewConn(nil, m.h, "", 2, "bob", nil, nil)) {
+		t.Fatal("register() = true after shutdown")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 
 	"collabServer/backend/internal/collab"
 
@@ -37,10 +38,15 @@ type Manager struct {
 	h   *Hub
 	svc collab.Service
 	sem *collab.SemaphoreControl
+
+	// 所有活跃连接（包括还没加入文档房间的），停机时逐个通知并关闭
+	mu           sync.Mutex
+	conns        map[*Conn]struct{}
+	shuttingDown bool
 }
 
 func NewManager(h *Hub, svc collab.Service, sem *collab.SemaphoreControl) *Manager {
-	return &Manager{h: h, svc: svc, sem: sem}
+	return &Manager{h: h, svc: svc, sem: sem, conns: make(map[*Conn]struct{})}
 }
 
 func (m *Manager) WebSocketConnect(c *gin.Context, h *Hub) {
@@ -55,6 +61,11 @@ func (m *Manager) WebSocketConnect(c *gin.Context, h *Hub) {
 	// 鉴权......还不会写
 	// lastRev := c.Query("lastKnownRevision")
 
+	if m.isShuttingDown() {
+		c.String(http.StatusServiceUnavailable, "server shutting down")
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("websocket upgrade error: %v (origin=%s)", err, c.Request.Header.Get("Origin"))
@@ -68,6 +79,9 @@ func (m *Manager) WebSocketConnect(c *gin.Context, h *Hub) {
 	// _ = conn.WriteJSON(gin.H{"type": "welcome", "docId": docID, "revision": 0})
 
 	wsConn := NewConn(conn, m.h, "", userIDUint64, username, m.svc, m.sem)
+	if !m.register(wsConn) {
+		return
+	}
 
 	// 先启动写循环，确保后续写入 send 通道的消息可以被及时发送
 	go wsConn.writeLoop()
@@ -76,4 +90,10 @@ func (m *Manager) WebSocketConnect(c *gin.Context, h *Hub) {
 
 	// 最后再进入读循环（阻塞至连接关闭）
 	wsConn.readLoop(c.Request.Context())
+	// 连接断开后先离开房间、注销，不再收到广播和停机通知，再让写循环退出；房间清空时会触发快照
+	if wsConn.docID != "" {
+		m.h.Leave(wsConn.docID, wsConn)
+	}
+	m.unregister(wsConn)
+	wsConn.markClosed()
 }
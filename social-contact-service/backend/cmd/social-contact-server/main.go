@@ -16,6 +16,8 @@ import (
 	"gorm.io/gorm"
 
 	"social-contact-service/backend/internal/cache"
+	"social-contact-service/backend/internal/consumer"
+	"social-contact-service/backend/internal/entity"
 	"social-contact-service/backend/internal/handler"
 	"social-contact-service/backend/internal/httpapi/middleware"
 	"social-contact-service/backend/internal/mysqldb"
@@ -35,6 +37,8 @@ type SocialContactConfig struct {
 	Kafka struct {
 		Brokers []string `mapstructure:"brokers"`
 		Topic   string   `mapstructure:"topic"`
+		// doc-ops 投影的消费组，为空时不启动消费者
+		GroupID string `mapstructure:"groupId"`
 	} `mapstructure:"kafka"`
 	Auth struct {
 		Path string `mapstructure:"path"`
@@ -84,6 +88,23 @@ func main() {
 	interactionRepo := cache.NewRedisInteraction(rdb, sf, docStatsRepo)
 	h := handler.NewPresenceHandler(interactionRepo)
 
+	// doc-ops 投影：编辑统计 / 作者贡献 / 文档动态
+	if err := db.AutoMigrate(&entity.DocStats{}, &entity.DocContribution{}, &entity.DocActivity{}); err != nil {
+		log.Fatalf("migrate doc stats tables failed: %v", err)
+	}
+	activityRepo := mysqldb.NewMySQLActivityRepo(db)
+	ah := handler.NewActivityHandler(docStatsRepo, activityRepo)
+	consumeCtx, stopConsume := context.WithCancel(context.Background())
+	defer stopConsume()
+	if cfg.Kafka.GroupID != "" {
+		docOps, err := consumer.NewDocOpsConsumer(cfg.Kafka.Brokers, cfg.Kafka.GroupID, cfg.Kafka.Topic, activityRepo)
+		if err != nil {
+			log.Fatalf("create doc-ops consumer failed: %v", err)
+		}
+		defer docOps.Close()
+		go docOps.Run(consumeCtx)
+	}
+
 	router := gin.New()
 	router.Use(gin.Recovery())
 	router.Use(gin.Logger())
@@ -119,6 +140,10 @@ func main() {
 		r.GET("/like/value", h.GetLike())
 		r.GET("/question_mark/value", h.GetQuestionMark())
 		r.GET("/share/value", h.GetShare())
+
+		r.GET("/edits/stats", ah.GetEditStats())
+		r.GET("/edits/contributors", ah.GetContributors())
+		r.GET("/activity", ah.GetActivity())
 	}
 	router.Run(fmt.Sprintf(":%d", cfg.Running.Port))
 }
@@ -0,0 +1,184 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+
+	"social-contact-service/backend/internal/repo"
+)
+
+// doc-ops 事件信封（collab-service 发出，schemaVersion 1），只解析投影需要的字段。
+// 消息体按 contentType 头解码：application/json（默认，没有该头时也按 JSON）或 application/x-protobuf
+type docEvent struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	EventID       string          `json:"eventId"`
+	EventType     string          `json:"eventType"`
+	OccurredAt    time.Time       `json:"occurredAt"`
+	DocID         string          `json:"docId"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// 各类事件 payload 中的操作人与版本号
+type eventPayload struct {
+	Revision   uint64 `json:"revision"`
+	AuthorID   uint64 `json:"authorId"`   // OP_APPLIED / SNAPSHOT_RESTORED
+	OwnerID    uint64 `json:"ownerId"`    // DOCUMENT_CREATED
+	ArchivedBy uint64 `json:"archivedBy"` // DOCUMENT_ARCHIVED
+	UserID     uint64 `json:"userId"`     // MEMBER_JOINED / MEMBER_LEFT
+}
+
+const (
+	supportedSchemaVersion = 1
+	eventOpApplied         = "OP_APPLIED"
+
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+var errUnsupportedEvent = errors.New("UNSUPPORTED_EVENT")
+
+// DocOpsConsumer：以消费组方式读取 doc-ops，把事件投影为编辑统计、作者贡献与文档动态。
+// 投影按 eventId 幂等，消息处理成功后才提交位点（至少一次）；
+// 写库失败时原地退避重试，不跳过也不打乱同一分区内的顺序。
+type DocOpsConsumer struct {
+	group sarama.ConsumerGroup
+	topic string
+	repo  repo.DocActivityRepo
+}
+
+func NewDocOpsConsumer(brokers []string, groupID, topic string, r repo.DocActivityRepo) (*DocOpsConsumer, error) {
+	cfg := sarama.NewConfig()
+	// 第一次启动的消费组从最早的事件开始，补齐历史统计
+	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	cfg.Consumer.Return.Errors = true
+	group, err := sarama.NewConsumerGroup(brokers, groupID, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &DocOpsConsumer{group: group, topic: topic, repo: r}, nil
+}
+
+// Run 阻塞消费直到 ctx 取消；重平衡后自动重新加入消费组
+func (c *DocOpsConsumer) Run(ctx context.Context) {
+	go func() {
+		for err := range c.group.Errors() {
+			log.Printf("doc-ops consumer error: %v", err)
+		}
+	}()
+	for ctx.Err() == nil {
+		if err := c.group.Consume(ctx, []string{c.topic}, c); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return
+			}
+			log.Printf("doc-ops consume error: %v", err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+func (c *DocOpsConsumer) Close() error {
+	return c.group.Close()
+}
+
+func (c *DocOpsConsumer) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (c *DocOpsConsumer) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (c *DocOpsConsumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := c.handle(sess.Context(), msg); err != nil {
+				// 会话结束（重平衡 / 停机）：不提交位点，由下一个持有该分区的消费者重新处理
+				return nil
+			}
+			sess.MarkMessage(msg, "")
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+}
+
+// 处理一条消息；只有 ctx 结束时才返回错误
+func (c *DocOpsConsumer) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	evt, err := decodeEvent(msg)
+	if err != nil {
+		// 无法解析或不支持的消息重试也没有意义，跳过
+		log.Printf("skip doc-ops message partition=%d offset=%d: %v", msg.Partition, msg.Offset, err)
+		return nil
+	}
+	backoff := 100 * time.Millisecond
+	for {
+		_, err := c.repo.ApplyEvent(ctx, evt)
+		if err == nil {
+			return nil
+		}
+		log.Printf("apply doc-ops event %s (doc=%s) failed, retry in %s: %v", evt.EventID, evt.DocID, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func decodeEvent(msg *sarama.ConsumerMessage) (repo.DocEditEvent, error) {
+	contentType := contentTypeJSON
+	for _, h := range msg.Headers {
+		if string(h.Key) == "contentType" {
+			contentType = string(h.Value)
+		}
+	}
+	var evt docEvent
+	var p eventPayload
+	switch contentType {
+	case contentTypeJSON:
+		if err := json.Unmarshal(msg.Value, &evt); err != nil {
+			return repo.DocEditEvent{}, err
+		}
+		if len(evt.Payload) > 0 {
+			if err := json.Unmarshal(evt.Payload, &p); err != nil {
+				return repo.DocEditEvent{}, err
+			}
+		}
+	case contentTypeProtobuf:
+		var err error
+		if evt, p, err = decodeProtoEvent(msg.Value); err != nil {
+			return repo.DocEditEvent{}, err
+		}
+	default:
+		return repo.DocEditEvent{}, fmt.Errorf("%w: content type %s", errUnsupportedEvent, contentType)
+	}
+	if evt.SchemaVersion != supportedSchemaVersion || evt.EventID == "" || evt.DocID == "" {
+		return repo.DocEditEvent{}, fmt.Errorf("%w: schemaVersion=%d eventId=%q", errUnsupportedEvent, evt.SchemaVersion, evt.EventID)
+	}
+	actor := p.AuthorID
+	for _, id := range []uint64{p.OwnerID, p.ArchivedBy, p.UserID} {
+		if actor == 0 {
+			actor = id
+		}
+	}
+	return repo.DocEditEvent{
+		EventID:    evt.EventID,
+		EventType:  evt.EventType,
+		DocID:      evt.DocID,
+		ActorID:    actor,
+		Revision:   p.Revision,
+		IsEdit:     evt.EventType == eventOpApplied,
+		OccurredAt: evt.OccurredAt,
+	}, nil
+}
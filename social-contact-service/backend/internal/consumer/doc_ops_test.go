@@ -0,0 +1,109 @@
+package consumer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func message(contentType string, value []byte) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{Value: value}
+	if contentType != "" {
+		msg.Headers = []*sarama.RecordHeader{{Key: []byte("contentType"), Value: []byte(contentType)}}
+	}
+	return msg
+}
+
+func TestDecodeEvent_JSONOpApplied(t *testing.T) {
+	raw := `{"schemaVersion":1,"eventId":"e1","eventType":"OP_APPLIED","occurredAt":"2024-01-02T03:04:05Z",
+		"docId":"d1","payload":{"revision":7,"authorId":42,"ops":[{"insert":"x"}]}}`
+	for _, ct := range []string{"", contentTypeJSON} {
+		evt, err := decodeEvent(message(ct, []byte(raw)))
+		if err != nil {
+			t.Fatalf("decodeEvent(contentType=%q) error = %v", ct, err)
+		}
+		if evt.EventID != "e1" || evt.DocID != "d1" || evt.ActorID != 42 || evt.Revision != 7 || !evt.IsEdit {
+			t.Fatalf("decodeEvent(contentType=%q) = %+v", ct, evt)
+		}
+		if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !evt.OccurredAt.Equal(want) {
+			t.Fatalf("OccurredAt = %v, want %v", evt.OccurredAt, want)
+		}
+	}
+}
+
+func TestDecodeEvent_JSONActorFallback(t *testing.T) {
+	cases := map[string]string{
+		"DOCUMENT_CREATED":  `{"ownerId":5,"title":"t"}`,
+		"DOCUMENT_ARCHIVED": `{"archivedBy":5}`,
+		"MEMBER_JOINED":     `{"userId":5,"username":"u"}`,
+	}
+	for typ, payload := range cases {
+		raw := `{"schemaVersion":1,"eventId":"e","eventType":"` + typ + `","docId":"d","payload":` + payload + `}`
+		evt, err := decodeEvent(message("", []byte(raw)))
+		if err != nil || evt.ActorID != 5 || evt.IsEdit {
+			t.Fatalf("decodeEvent(%s) = %+v, %v; want actor 5, not an edit", typ, evt, err)
+		}
+	}
+}
+
+func TestDecodeEvent_Protobuf(t *testing.T) {
+	var ts, op, b []byte
+	ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, 1704164645)
+	ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, 500)
+
+	op = protowire.AppendTag(op, 1, protowire.BytesType)
+	op = protowire.AppendString(op, "op-1")
+	op = protowire.AppendTag(op, 2, protowire.VarintType)
+	op = protowire.AppendVarint(op, 7)
+	op = protowire.AppendTag(op, 3, protowire.VarintType)
+	op = protowire.AppendVarint(op, 42)
+	op = protowire.AppendTag(op, 7, protowire.BytesType)
+	op = protowire.AppendString(op, `[{"insert":"x"}]`)
+
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, "e1")
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendString(b, "OP_APPLIED")
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendBytes(b, ts)
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	b = protowire.AppendString(b, "d1")
+	b = protowire.AppendTag(b, 13, protowire.BytesType)
+	b = protowire.AppendBytes(b, op)
+
+	evt, err := decodeEvent(message(contentTypeProtobuf, b))
+	if err != nil {
+		t.Fatalf("decodeEvent() error = %v", err)
+	}
+	if evt.EventID != "e1" || evt.EventType != "OP_APPLIED" || evt.DocID != "d1" ||
+		evt.ActorID != 42 || evt.Revision != 7 || !evt.IsEdit {
+		t.Fatalf("decodeEvent() = %+v", evt)
+	}
+	if want := time.Unix(1704164645, 500).UTC(); !evt.OccurredAt.Equal(want) {
+		t.Fatalf("OccurredAt = %v, want %v", evt.OccurredAt, want)
+	}
+
+	if _, err := decodeEvent(message(contentTypeProtobuf, b[:len(b)-3])); err == nil {
+		t.Fatalf("decodeEvent(truncated) succeeded, want error")
+	}
+}
+
+func TestDecodeEvent_RejectsUnsupported(t *testing.T) {
+	cases := []*sarama.ConsumerMessage{
+		message("", []byte(`{"schemaVersion":2,"eventId":"e","eventType":"OP_APPLIED","docId":"d"}`)),
+		message("", []byte(`{"schemaVersion":1,"eventType":"OP_APPLIED","docId":"d"}`)),
+		message("application/avro", []byte(`{}`)),
+	}
+	for i, msg := range cases {
+		if _, err := decodeEvent(msg); !errors.Is(err, errUnsupportedEvent) {
+			t.Fatalf("case %d: decodeEvent() error = %v, want %v", i, err, errUnsupportedEvent)
+		}
+	}
+}
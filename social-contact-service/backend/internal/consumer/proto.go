@@ -0,0 +1,112 @@
+package consumer
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// collab-service 在 events.encoding: protobuf 时发出的 DocEvent（schema 见 collab/doc_event.proto），
+// 这里只解析投影需要的字段，未知字段直接跳过
+
+// payload oneof 的字段编号 -> 该消息中 revision / 操作人字段的编号（0 表示没有）
+var protoPayloadFields = map[protowire.Number]struct{ revision, actor protowire.Number }{
+	10: {0, 1}, // DocumentCreated.owner_id
+	11: {0, 0}, // DocumentRenamed
+	12: {0, 1}, // DocumentArchived.archived_by
+	13: {2, 3}, // OpApplied.revision / author_id
+	14: {1, 0}, // SnapshotSaved.revision
+	15: {2, 4}, // SnapshotRestored.revision / author_id
+	16: {0, 1}, // Member.user_id（MEMBER_JOINED）
+	17: {0, 1}, // Member.user_id（MEMBER_LEFT）
+}
+
+func decodeProtoEvent(b []byte) (docEvent, eventPayload, error) {
+	var evt docEvent
+	var p eventPayload
+	err := walkProto(b, func(num protowire.Number, v uint64, bs []byte) error {
+		switch num {
+		case 1:
+			evt.SchemaVersion = int(v)
+		case 2:
+			evt.EventID = string(bs)
+		case 3:
+			evt.EventType = string(bs)
+		case 4:
+			t, err := decodeProtoTimestamp(bs)
+			if err != nil {
+				return err
+			}
+			evt.OccurredAt = t
+		case 5:
+			evt.DocID = string(bs)
+		default:
+			fields, ok := protoPayloadFields[num]
+			if !ok {
+				return nil
+			}
+			return walkProto(bs, func(n protowire.Number, v uint64, _ []byte) error {
+				switch {
+				case fields.revision != 0 && n == fields.revision:
+					p.Revision = v
+				case fields.actor != 0 && n == fields.actor:
+					p.AuthorID = v
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return evt, p, err
+}
+
+// google.protobuf.Timestamp{seconds = 1, nanos = 2}
+func decodeProtoTimestamp(b []byte) (time.Time, error) {
+	var sec, nsec uint64
+	err := walkProto(b, func(num protowire.Number, v uint64, _ []byte) error {
+		switch num {
+		case 1:
+			sec = v
+		case 2:
+			nsec = v
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(sec), int64(nsec)).UTC(), nil
+}
+
+// 依次回调每个 varint / length-delimited 字段，其余类型跳过
+func walkProto(b []byte, fn func(num protowire.Number, v uint64, bs []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("decode protobuf tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v uint64
+		var bs []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			bs, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("decode protobuf field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ != protowire.VarintType && typ != protowire.BytesType {
+			continue
+		}
+		if err := fn(num, v, bs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
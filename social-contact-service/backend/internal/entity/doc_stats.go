@@ -8,6 +8,30 @@ type DocStats struct {
 	ViewCount         uint64 `gorm:"default:0"`
 	ShareCount        uint64 `gorm:"default:0"`
 	QuestionMarkCount uint64 `gorm:"default:0"`
-	CreatedAt         time.Time
-	UpdatedAt         time.Time
+	// 以下由 doc-ops 事件投影维护
+	EditCount    uint64     `gorm:"default:0"`
+	LastRevision uint64     `gorm:"default:0"`
+	LastEditorID uint64     `gorm:"default:0"`
+	LastEditedAt *time.Time // 从未被编辑过时为 NULL
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// DocContribution 每个作者在文档上的编辑次数
+type DocContribution struct {
+	DocID        string `gorm:"primaryKey;type:varchar(64)"`
+	AuthorID     uint64 `gorm:"primaryKey"`
+	EditCount    uint64 `gorm:"default:0"`
+	LastEditedAt time.Time
+}
+
+// DocActivity 文档动态：每条 doc-ops 事件一条记录，EventID 唯一，重复消费的事件会被忽略
+type DocActivity struct {
+	ID         uint64    `gorm:"primaryKey;autoIncrement"`
+	EventID    string    `gorm:"type:varchar(64);uniqueIndex"`
+	DocID      string    `gorm:"type:varchar(64);index:idx_doc_activity,priority:1"`
+	EventType  string    `gorm:"type:varchar(32)"`
+	ActorID    uint64    `gorm:"default:0"` // 操作人（编辑作者、创建者、加入 / 离开的成员），系统事件为 0
+	Revision   uint64    `gorm:"default:0"`
+	OccurredAt time.Time `gorm:"index:idx_doc_activity,priority:2"`
 }
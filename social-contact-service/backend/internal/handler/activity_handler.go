@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"social-contact-service/backend/internal/repo"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// ActivityHandler 文档编辑统计、作者贡献与最近动态（由 doc-ops 事件投影而来）
+type ActivityHandler struct {
+	stats    repo.DocStatsRepo
+	activity repo.DocActivityRepo
+}
+
+func NewActivityHandler(stats repo.DocStatsRepo, activity repo.DocActivityRepo) *ActivityHandler {
+	return &ActivityHandler{stats: stats, activity: activity}
+}
+
+// ?limit=，缺省 20，最大 100
+func queryLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		return defaultListLimit
+	}
+	return min(limit, maxListLimit)
+}
+
+// GET /social/edits/stats：编辑次数、最后编辑版本 / 作者 / 时间
+func (h *ActivityHandler) GetEditStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		docID := queryDocID(c)
+		if docID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing doc_id"})
+			return
+		}
+		stats, err := h.stats.GetDocStats(c.Request.Context(), docID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if stats == nil {
+			c.JSON(http.StatusOK, gin.H{"docId": docID, "editCount": 0})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"docId":        docID,
+			"editCount":    stats.EditCount,
+			"lastRevision": stats.LastRevision,
+			"lastEditorId": stats.LastEditorID,
+			"lastEditedAt": stats.LastEditedAt,
+		})
+	}
+}
+
+// GET /social/edits/contributors：按编辑次数倒序的作者列表
+func (h *ActivityHandler) GetContributors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		docID := queryDocID(c)
+		if docID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing doc_id"})
+			return
+		}
+		list, err := h.activity.ListContributors(c.Request.Context(), docID, queryLimit(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := make([]gin.H, 0, len(list))
+		for _, item := range list {
+			out = append(out, gin.H{"authorId": item.AuthorID, "editCount": item.EditCount, "lastEditedAt": item.LastEditedAt})
+		}
+		c.JSON(http.StatusOK, gin.H{"docId": docID, "contributors": out})
+	}
+}
+
+// GET /social/activity：最近动态，时间倒序
+func (h *ActivityHandler) GetActivity() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		docID := queryDocID(c)
+		if docID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing doc_id"})
+			return
+		}
+		list, err := h.activity.ListActivity(c.Request.Context(), docID, queryLimit(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		out := make([]gin.H, 0, len(list))
+		for _, item := range list {
+			out = append(out, gin.H{
+				"eventId":    item.EventID,
+				"eventType":  item.EventType,
+				"actorId":    item.ActorID,
+				"revision":   item.Revision,
+				"occurredAt": item.OccurredAt,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"docId": docID, "activity": out})
+	}
+}
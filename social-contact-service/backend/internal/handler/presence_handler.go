@@ -64,22 +64,27 @@ func (h *PresenceHandler) makePostDocHandler(
 	}
 }
 
+// 兼容多种前端传参方式：?doc_id= / ?docId= / Header: docid
+func queryDocID(c *gin.Context) string {
+	docID := c.Query("doc_id")
+	if docID == "" {
+		docID = c.Query("docId")
+	}
+	if docID == "" {
+		docID = c.GetHeader("docid")
+	}
+	if docID == "" {
+		docID = c.GetHeader("docId")
+	}
+	return docID
+}
+
 // 注意 GET 请求的参数是通过 URL 传递的，所以需要使用 Query 方法获取
 func (h *PresenceHandler) makeGetDocHandler(
 	fn func(context.Context, string) (uint64, error),
 ) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 兼容多种前端传参方式：?doc_id= / ?docId= / Header: docid
-		docID := c.Query("doc_id")
-		if docID == "" {
-			docID = c.Query("docId")
-		}
-		if docID == "" {
-			docID = c.GetHeader("docid")
-		}
-		if docID == "" {
-			docID = c.GetHeader("docId")
-		}
+		docID := queryDocID(c)
 		if docID == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "missing doc_id"})
 			return
@@ -0,0 +1,92 @@
+package mysqldb
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"social-contact-service/backend/internal/entity"
+	"social-contact-service/backend/internal/repo"
+)
+
+type mysqlActivityRepo struct {
+	db *gorm.DB
+}
+
+func NewMySQLActivityRepo(db *gorm.DB) repo.DocActivityRepo {
+	return &mysqlActivityRepo{db: db}
+}
+
+func (r *mysqlActivityRepo) ApplyEvent(ctx context.Context, evt repo.DocEditEvent) (bool, error) {
+	applied := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		activity := entity.DocActivity{
+			EventID:    evt.EventID,
+			DocID:      evt.DocID,
+			EventType:  evt.EventType,
+			ActorID:    evt.ActorID,
+			Revision:   evt.Revision,
+			OccurredAt: evt.OccurredAt,
+		}
+		// EventID 唯一：重复投递的事件插入不进去，后面的计数也不再累加
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&activity)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		applied = true
+		if !evt.IsEdit {
+			return nil
+		}
+
+		// 乱序到达的旧事件只累加次数，不回退最后编辑信息
+		if err := tx.Exec(
+			`INSERT INTO doc_stats (doc_id, edit_count, last_revision, last_editor_id, last_edited_at, created_at, updated_at)
+			VALUES (?, 1, ?, ?, ?, NOW(), NOW())
+			ON DUPLICATE KEY UPDATE
+				edit_count = edit_count + 1,
+				last_editor_id = IF(VALUES(last_revision) > last_revision, VALUES(last_editor_id), last_editor_id),
+				last_edited_at = IF(VALUES(last_revision) > last_revision, VALUES(last_edited_at), last_edited_at),
+				last_revision = GREATEST(last_revision, VALUES(last_revision)),
+				updated_at = NOW()`,
+			evt.DocID, evt.Revision, evt.ActorID, evt.OccurredAt,
+		).Error; err != nil {
+			return err
+		}
+		return tx.Exec(
+			`INSERT INTO doc_contributions (doc_id, author_id, edit_count, last_edited_at)
+			VALUES (?, ?, 1, ?)
+			ON DUPLICATE KEY UPDATE
+				edit_count = edit_count + 1,
+				last_edited_at = GREATEST(last_edited_at, VALUES(last_edited_at))`,
+			evt.DocID, evt.ActorID, evt.OccurredAt,
+		).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return applied, nil
+}
+
+func (r *mysqlActivityRepo) ListContributors(ctx context.Context, docID string, limit int) ([]entity.DocContribution, error) {
+	var out []entity.DocContribution
+	err := r.db.WithContext(ctx).
+		Where("doc_id = ?", docID).
+		Order("edit_count DESC").
+		Limit(limit).
+		Find(&out).Error
+	return out, err
+}
+
+func (r *mysqlActivityRepo) ListActivity(ctx context.Context, docID string, limit int) ([]entity.DocActivity, error) {
+	var out []entity.DocActivity
+	err := r.db.WithContext(ctx).
+		Where("doc_id = ?", docID).
+		Order("occurred_at DESC, id DESC").
+		Limit(limit).
+		Find(&out).Error
+	return out, err
+}
@@ -0,0 +1,143 @@
+package mysqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gormmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"social-contact-service/backend/internal/repo"
+)
+
+// 记录执行过的语句的假 MySQL 连接：doc_activities 按第一个参数（event_id）去重，模拟唯一索引
+type recordingDB struct {
+	mu     sync.Mutex
+	events map[string]bool
+	stmts  []string
+	args   [][]driver.NamedValue
+}
+
+func (db *recordingDB) Connect(context.Context) (driver.Conn, error) {
+	return &recordingConn{db: db}, nil
+}
+func (db *recordingDB) Driver() driver.Driver { return nil }
+
+func (db *recordingDB) executed(table string) [][]driver.NamedValue {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var out [][]driver.NamedValue
+	for i, q := range db.stmts {
+		if strings.Contains(q, table) {
+			out = append(out, db.args[i])
+		}
+	}
+	return out
+}
+
+type recordingConn struct{ db *recordingDB }
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *recordingConn) Close() error                        { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error)           { return recordingTx{c.db}, nil }
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.stmts = append(c.db.stmts, query)
+	c.db.args = append(c.db.args, args)
+	if strings.Contains(query, "doc_activities") {
+		id := args[0].Value.(string)
+		if c.db.events[id] {
+			return execResult(0), nil
+		}
+		c.db.events[id] = true
+	}
+	return execResult(1), nil
+}
+
+type execResult int64
+
+func (r execResult) LastInsertId() (int64, error) { return int64(r), nil }
+func (r execResult) RowsAffected() (int64, error) { return int64(r), nil }
+
+type recordingTx struct{ db *recordingDB }
+
+func (tx recordingTx) Commit() error   { return nil }
+func (tx recordingTx) Rollback() error { return nil }
+
+func newRecordingRepo(t *testing.T) (*recordingDB, repo.DocActivityRepo) {
+	rec := &recordingDB{events: map[string]bool{}}
+	db, err := gorm.Open(gormmysql.New(gormmysql.Config{
+		Conn:                      sql.OpenDB(rec),
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("gorm.Open() error = %v", err)
+	}
+	return rec, NewMySQLActivityRepo(db)
+}
+
+func TestApplyEvent_EditUpdatesStatsOnce(t *testing.T) {
+	rec, r := newRecordingRepo(t)
+	ctx := context.Background()
+	evt := repo.DocEditEvent{
+		EventID:    "evt-1",
+		EventType:  "OP_APPLIED",
+		DocID:      "doc-1",
+		ActorID:    42,
+		Revision:   7,
+		IsEdit:     true,
+		OccurredAt: time.Unix(1700000000, 0),
+	}
+
+	if applied, err := r.ApplyEvent(ctx, evt); err != nil || !applied {
+		t.Fatalf("ApplyEvent() = %v, %v; want applied", applied, err)
+	}
+	stats := rec.executed("doc_stats")
+	contribs := rec.executed("doc_contributions")
+	if len(stats) != 1 || len(contribs) != 1 {
+		t.Fatalf("stats upserts = %d, contribution upserts = %d; want 1 each", len(stats), len(contribs))
+	}
+	if got := stats[0][2].Value; got != int64(42) {
+		t.Fatalf("doc_stats last_editor_id = %v, want 42", got)
+	}
+	if got := contribs[0][1].Value; got != int64(42) {
+		t.Fatalf("doc_contributions author_id = %v, want 42", got)
+	}
+
+	// 重复投递：动态插入不进去，计数不再累加
+	if applied, err := r.ApplyEvent(ctx, evt); err != nil || applied {
+		t.Fatalf("ApplyEvent(duplicate) = %v, %v; want skipped", applied, err)
+	}
+	if n := len(rec.executed("doc_stats")); n != 1 {
+		t.Fatalf("stats upserts after duplicate = %d, want 1", n)
+	}
+}
+
+func TestApplyEvent_NonEditOnlyRecordsActivity(t *testing.T) {
+	rec, r := newRecordingRepo(t)
+	evt := repo.DocEditEvent{
+		EventID:    "evt-2",
+		EventType:  "MEMBER_JOINED",
+		DocID:      "doc-1",
+		ActorID:    9,
+		OccurredAt: time.Unix(1700000000, 0),
+	}
+	if applied, err := r.ApplyEvent(context.Background(), evt); err != nil || !applied {
+		t.Fatalf("ApplyEvent() = %v, %v; want applied", applied, err)
+	}
+	if n := len(rec.executed("doc_activities")); n != 1 {
+		t.Fatalf("activity inserts = %d, want 1", n)
+	}
+	if n := len(rec.executed("doc_stats")) + len(rec.executed("doc_contributions")); n != 0 {
+		t.Fatalf("stats / contribution upserts = %d, want 0", n)
+	}
+}
@@ -2,6 +2,8 @@ package repo
 
 import (
 	"context"
+	"time"
+
 	"social-contact-service/backend/internal/entity"
 )
 
@@ -24,3 +26,23 @@ type DocStatsRepo interface {
 	GetDocStats(ctx context.Context, docID string) (*entity.DocStats, error)
 	// SetDocStats(ctx context.Context, docID string, stats *entity.DocStats) error
 }
+
+// DocEditEvent doc-ops 事件中投影需要的部分
+type DocEditEvent struct {
+	EventID    string
+	EventType  string
+	DocID      string
+	ActorID    uint64
+	Revision   uint64
+	IsEdit     bool // OP_APPLIED：计入编辑次数与作者贡献
+	OccurredAt time.Time
+}
+
+// DocActivityRepo doc-ops 事件投影：编辑统计、作者贡献与文档动态
+type DocActivityRepo interface {
+	// ApplyEvent 在一个事务里写动态并更新统计；事件已处理过（EventID 重复）时返回 false
+	ApplyEvent(ctx context.Context, evt DocEditEvent) (bool, error)
+	ListContributors(ctx context.Context, docID string, limit int) ([]entity.DocContribution, error)
+	// 按时间倒序返回最近的动态
+	ListActivity(ctx context.Context, docID string, limit int) ([]entity.DocActivity, error)
+}